@@ -0,0 +1,406 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type groupGrant struct {
+	GroupID     int    `json:"group_id"`
+	Username    string `json:"username"`
+	Role        string `json:"role"`
+	GrantedBy   string `json:"granted_by"`
+	GrantedDate string `json:"granted_at"`
+}
+
+type statusError struct {
+	Code    int
+	Message string
+}
+
+func (e statusError) Error() string {
+	return e.Message
+}
+
+func getErrorCode(err error) int {
+	var se statusError
+	if errors.As(err, &se) {
+		return se.Code
+	}
+	return getStateErrorCode(err)
+}
+
+var roleLevels = map[string]int{"none": 0, "viewer": 1, "editor": 2, "owner": 3}
+
+var grants = readGrants()
+
+func readGrants() []groupGrant {
+	var gs []groupGrant
+	readJSONFile("grants.json", &gs)
+	return gs
+}
+
+func getGrantNum(groupID int, username string) int {
+	for i := 0; i < len(grants); i++ {
+		if grants[i].GroupID == groupID && grants[i].Username == username {
+			return i
+		}
+	}
+	return -1
+}
+
+func getGroupRole(grs []group, u user, id int) string {
+	if u.Admin {
+		return "owner"
+	}
+	visited := map[int]bool{}
+	for id != 0 && !visited[id] {
+		visited[id] = true
+		if n := getGrantNum(id, u.Username); n >= 0 {
+			return grants[n].Role
+		}
+		if !containsGroup(grs, id) {
+			break
+		}
+		id = getGroup(grs, id).ParentID
+	}
+	if role := config.GetString("RBAC.default_role"); roleLevels[role] > 0 {
+		return role
+	}
+	return "none"
+}
+
+func hasGroupRole(grs []group, u user, id int, role string) bool {
+	return roleLevels[getGroupRole(grs, u, id)] >= roleLevels[role]
+}
+
+func getKnownGroups() []group {
+	return append(append([]group(nil), taskGroups...), trash.Groups...)
+}
+
+func getVisibleGroups(grs []group, known []group, u user) []group {
+	var visible []group
+	for i := 0; i < len(grs); i++ {
+		if hasGroupRole(known, u, grs[i].GroupID, "viewer") {
+			visible = append(visible, grs[i])
+		}
+	}
+	return visible
+}
+
+func getVisibleTasks(ts []task, known []group, u user) []task {
+	var visible []task
+	for i := 0; i < len(ts); i++ {
+		if hasGroupRole(known, u, ts[i].GroupID, "viewer") {
+			visible = append(visible, ts[i])
+		}
+	}
+	return visible
+}
+
+func getVisibleRevisions(revs []revision, known []group, u user) []revision {
+	var visible []revision
+	for i := 0; i < len(revs); i++ {
+		groupID, _ := strconv.Atoi(revs[i].ResourceID)
+		if revs[i].Resource == "task" {
+			var t task
+			_ = json.Unmarshal(revs[i].Snapshot, &t)
+			groupID = t.GroupID
+		}
+		if revs[i].Snapshot == nil || hasGroupRole(known, u, groupID, "viewer") {
+			visible = append(visible, revs[i])
+		}
+	}
+	return visible
+}
+
+func getRouteGroup(template string, vars map[string]string) (int, bool) {
+	switch {
+	case strings.HasPrefix(template, "/groups/{id") || strings.HasPrefix(template, "/groups/children/") ||
+		strings.HasPrefix(template, "/tasks/group/") || strings.HasPrefix(template, "/trash/groups/"):
+		id, err := strconv.Atoi(vars["id"])
+		return id, err == nil
+	case strings.HasPrefix(template, "/tasks/{id"):
+		return getTaskGroupID(vars["id"]), true
+	case strings.HasPrefix(template, "/trash/tasks/"):
+		if n := getTrashTaskNum(vars["id"]); n >= 0 {
+			return trash.Tasks[n].GroupID, true
+		}
+	}
+	return 0, false
+}
+
+func getTaskGroupID(id string) int {
+	if containsTask(tasks, id) {
+		return tasks[getTaskNumByID(tasks, id)].GroupID
+	}
+	if n := getTrashTaskNum(id); n >= 0 {
+		return trash.Tasks[n].GroupID
+	}
+	for i := len(revisions) - 1; i >= 0; i-- {
+		var t task
+		if revisions[i].Resource == "task" && revisions[i].ResourceID == id && json.Unmarshal(revisions[i].Snapshot, &t) == nil {
+			return t.GroupID
+		}
+	}
+	return 0
+}
+
+func getRequiredRole(method string, template string) string {
+	switch {
+	case strings.HasSuffix(template, "/grants") || strings.HasSuffix(template, "/grants/{username}"):
+		return "owner"
+	case method == "DELETE" && template == "/groups/{id:[0-9]+}":
+		return "owner"
+	case method == "GET" || method == "HEAD":
+		return "viewer"
+	}
+	return "editor"
+}
+
+func checkGroupAccess(u user, id int, required string) error {
+	if u.Admin {
+		return nil
+	}
+	role := getGroupRole(getKnownGroups(), u, id)
+	if id == 0 || roleLevels[role] == 0 {
+		return statusError{http.StatusNotFound, "page not found"}
+	}
+	if roleLevels[role] < roleLevels[required] {
+		log.WithFields(log.Fields{"Username: ": u.Username, "Group ID: ": id}).Warn("Insufficient group role.")
+		return statusError{http.StatusForbidden, role + " role does not allow this request"}
+	}
+	return nil
+}
+
+func checkRouteAccess(w http.ResponseWriter, r *http.Request, template string) bool {
+	u, ok := requestUser(r)
+	if !ok || u.Admin {
+		return true
+	}
+	id, ok := getRouteGroup(template, mux.Vars(r))
+	if !ok {
+		return true
+	}
+	err := checkGroupAccess(u, id, getRequiredRole(r.Method, template))
+	if err == nil {
+		return true
+	}
+	if getErrorCode(err) == http.StatusNotFound {
+		http.NotFound(w, r)
+		return false
+	}
+	http.Error(w, "403 "+err.Error(), http.StatusForbidden)
+	return false
+}
+
+func checkGroupRole(r *http.Request, id int, role string) error {
+	u, ok := requestUser(r)
+	known := getKnownGroups()
+	if ok && (u.Admin || !containsGroup(known, id) || hasGroupRole(known, u, id, role)) {
+		return nil
+	}
+	log.WithFields(log.Fields{"Username: ": u.Username, "Group ID: ": id}).Warn("Insufficient group role.")
+	return statusError{http.StatusForbidden, role + " role is required in group " + strconv.Itoa(id)}
+}
+
+func requireGroupRole(w http.ResponseWriter, r *http.Request, id int, role string) bool {
+	err := checkGroupRole(r, id, role)
+	if err != nil {
+		http.Error(w, "403 "+err.Error(), http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+func grantCreator(r *http.Request, groupID int, parentID int) {
+	u, ok := requestUser(r)
+	if !ok || u.Admin || containsGroup(getKnownGroups(), parentID) {
+		return
+	}
+	grants = append(grants, groupGrant{GroupID: groupID, Username: u.Username, Role: "owner", GrantedBy: u.Username, GrantedDate: time.Now().Format(time.RFC3339Nano)})
+}
+
+func checkChangeSetAccess(w http.ResponseWriter, r *http.Request, cs changeSet, undo bool) bool {
+	for i := 0; i < len(cs.Changes); i++ {
+		c := cs.Changes[i]
+		snapshots := []json.RawMessage{c.Before, c.After}
+		for g := 0; g < len(snapshots); g++ {
+			if snapshots[g] == nil {
+				continue
+			}
+			role := "editor"
+			var groupID int
+			if c.Resource == "group" {
+				var gr group
+				_ = json.Unmarshal(snapshots[g], &gr)
+				groupID = gr.GroupID
+				if (undo && c.Before == nil) || (!undo && c.After == nil) {
+					role = "owner"
+				}
+				if !requireGroupRole(w, r, gr.ParentID, "editor") {
+					return false
+				}
+			} else {
+				var t task
+				_ = json.Unmarshal(snapshots[g], &t)
+				groupID = t.GroupID
+			}
+			if !requireGroupRole(w, r, groupID, role) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func serveRestricted(w http.ResponseWriter, r *http.Request, u user, next http.Handler) {
+	grs, ts, tr, revs := taskGroups, tasks, trash, revisions
+	defer func() {
+		taskGroups, tasks, trash, revisions = grs, ts, tr, revs
+	}()
+	known := getKnownGroups()
+	taskGroups = getVisibleGroups(grs, known, u)
+	tasks = getVisibleTasks(ts, known, u)
+	trash = trashBin{Groups: getVisibleGroups(tr.Groups, known, u), Tasks: getVisibleTasks(tr.Tasks, known, u)}
+	if r.URL.Query().Get("as_of") != "" {
+		revisions = getVisibleRevisions(revs, known, u)
+	}
+	next.ServeHTTP(w, r)
+}
+
+func accessMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u, ok := requestUser(r)
+		if !ok || u.Admin || isStreamRequest(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !checkRouteAccess(w, r, getRouteTemplate(r)) {
+			return
+		}
+		if r.Method == "GET" || r.Method == "HEAD" {
+			serveRestricted(w, r, u, next)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func setGrant(groupID int, username string, role string, actor string) error {
+	if _, ok := roleLevels[role]; !ok {
+		return errors.New("unknown role " + role)
+	}
+	authMu.Lock()
+	exists := getUserNum(users, username) >= 0
+	authMu.Unlock()
+	if !exists {
+		return errors.New("user with this name does not exist")
+	}
+	g := groupGrant{GroupID: groupID, Username: username, Role: role, GrantedBy: actor, GrantedDate: time.Now().Format(time.RFC3339Nano)}
+	if n := getGrantNum(groupID, username); n >= 0 {
+		grants[n] = g
+		return nil
+	}
+	grants = append(grants, g)
+	return nil
+}
+
+func removeGrant(groupID int, username string) bool {
+	n := getGrantNum(groupID, username)
+	if n < 0 {
+		return false
+	}
+	grants = append(grants[:n], grants[n+1:]...)
+	return true
+}
+
+func removeUserGrants(username string) {
+	var gs []groupGrant
+	for i := 0; i < len(grants); i++ {
+		if grants[i].Username != username {
+			gs = append(gs, grants[i])
+		}
+	}
+	grants = gs
+}
+
+func grantsHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	log.WithFields(log.Fields{"method": r.Method, "url": r.URL.String()}).Info("grantsHandler started")
+	vars := mux.Vars(r)
+	ID, err := strconv.Atoi(vars["id"])
+	if err != nil || !containsGroup(taskGroups, ID) {
+		http.NotFound(w, r)
+		return
+	}
+	gs := []groupGrant{}
+	for i := 0; i < len(grants); i++ {
+		if grants[i].GroupID == ID {
+			gs = append(gs, grants[i])
+		}
+	}
+	err = json.NewEncoder(w).Encode(gs)
+	end := time.Now()
+	execTime := end.Sub(start)
+	if err != nil {
+		log.WithFields(log.Fields{"execution time": execTime}).Fatal("grantsHandler ended: " + err.Error())
+	}
+	log.WithFields(log.Fields{"execution time": execTime}).Info("grantsHandler ended")
+}
+
+func grantHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	log.WithFields(log.Fields{"method": r.Method, "url": r.URL.String(), "body": r.Body}).Info("grantHandler started")
+	vars := mux.Vars(r)
+	ID, err := strconv.Atoi(vars["id"])
+	if err != nil || !containsGroup(taskGroups, ID) {
+		http.NotFound(w, r)
+		return
+	}
+	var g groupGrant
+	err = json.NewDecoder(r.Body).Decode(&g)
+	if err != nil {
+		http.Error(w, "400 "+err.Error(), http.StatusBadRequest)
+		log.Error("Decoding grant from request body: ", err.Error())
+		return
+	}
+	err = setGrant(ID, vars["username"], g.Role, requestActor(r))
+	if err != nil {
+		http.Error(w, "400 "+err.Error(), http.StatusBadRequest)
+		log.WithField("Group ID: ", ID).Warn("Granting role: ", err.Error())
+		return
+	}
+	err = json.NewEncoder(w).Encode(grants[getGrantNum(ID, vars["username"])])
+	end := time.Now()
+	execTime := end.Sub(start)
+	if err != nil {
+		log.WithFields(log.Fields{"execution time": execTime}).Fatal("grantHandler ended: " + err.Error())
+	}
+	log.WithFields(log.Fields{"execution time": execTime}).Info("grantHandler ended")
+}
+
+func grantDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	log.WithFields(log.Fields{"method": r.Method, "url": r.URL.String()}).Info("grantDeleteHandler started")
+	vars := mux.Vars(r)
+	ID, err := strconv.Atoi(vars["id"])
+	if err != nil || !removeGrant(ID, vars["username"]) {
+		http.NotFound(w, r)
+		return
+	}
+	_, err = fmt.Fprint(w, "grant removed")
+	end := time.Now()
+	execTime := end.Sub(start)
+	if err != nil {
+		log.WithFields(log.Fields{"execution time": execTime}).Fatal("grantDeleteHandler ended: " + err.Error())
+	}
+	log.WithFields(log.Fields{"execution time": execTime}).Info("grantDeleteHandler ended")
+}
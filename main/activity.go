@@ -0,0 +1,321 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+type comment struct {
+	CommentID   int    `json:"comment_id"`
+	TaskID      string `json:"task_id"`
+	Author      string `json:"author"`
+	Text        string `json:"text"`
+	CreatedDate string `json:"created_at"`
+	UpdatedDate string `json:"updated_at,omitempty"`
+}
+
+type activityEvent struct {
+	EventID     int               `json:"event_id"`
+	TaskID      string            `json:"task_id"`
+	Type        string            `json:"type"`
+	Actor       string            `json:"actor"`
+	Details     map[string]string `json:"details,omitempty"`
+	CreatedDate string            `json:"created_at"`
+}
+
+type activityItem struct {
+	Kind    string         `json:"kind"`
+	Time    string         `json:"time"`
+	Comment *comment       `json:"comment,omitempty"`
+	Event   *activityEvent `json:"event,omitempty"`
+}
+
+var comments = readComments()
+
+var activity = readActivity()
+
+func readComments() []comment {
+	var cs []comment
+	readJSONFile("comments.json", &cs)
+	return cs
+}
+
+func readActivity() []activityEvent {
+	var events []activityEvent
+	readJSONFile("activity.json", &events)
+	return events
+}
+
+func requestActor(r *http.Request) string {
+	if u, ok := requestUser(r); ok {
+		return u.Username
+	}
+	return "anonymous"
+}
+
+func logActivity(taskID string, kind string, details map[string]string) {
+	id := 0
+	if len(activity) != 0 {
+		id = activity[len(activity)-1].EventID
+	}
+	activity = append(activity, activityEvent{
+		EventID:     nextID("activity", id),
+		TaskID:      taskID,
+		Type:        kind,
+		Details:     details,
+		CreatedDate: time.Now().Format(time.RFC3339Nano),
+	})
+}
+
+func setActivityActor(mark int, actor string) {
+	for i := mark; i < len(activity); i++ {
+		if activity[i].Actor == "" {
+			activity[i].Actor = actor
+		}
+	}
+}
+
+func renameActivityTask(oldID string, newID string) {
+	for i := 0; i < len(comments); i++ {
+		if comments[i].TaskID == oldID {
+			comments[i].TaskID = newID
+		}
+	}
+	for i := 0; i < len(activity); i++ {
+		if activity[i].TaskID == oldID {
+			activity[i].TaskID = newID
+		}
+	}
+}
+
+func removeTaskActivity(id string) {
+	var cs []comment
+	for i := 0; i < len(comments); i++ {
+		if comments[i].TaskID != id {
+			cs = append(cs, comments[i])
+		}
+	}
+	comments = cs
+	var events []activityEvent
+	for i := 0; i < len(activity); i++ {
+		if activity[i].TaskID != id {
+			events = append(events, activity[i])
+		}
+	}
+	activity = events
+}
+
+func getTaskComments(id string) []comment {
+	cs := []comment{}
+	for i := 0; i < len(comments); i++ {
+		if comments[i].TaskID == id {
+			cs = append(cs, comments[i])
+		}
+	}
+	return cs
+}
+
+func getCommentNumByID(id int) int {
+	for i := 0; i < len(comments); i++ {
+		if comments[i].CommentID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func getTaskActivity(id string) []activityItem {
+	var items []activityItem
+	for i := 0; i < len(comments); i++ {
+		if comments[i].TaskID == id {
+			c := comments[i]
+			items = append(items, activityItem{Kind: "comment", Time: c.CreatedDate, Comment: &c})
+		}
+	}
+	for i := 0; i < len(activity); i++ {
+		if activity[i].TaskID == id {
+			e := activity[i]
+			items = append(items, activityItem{Kind: "event", Time: e.CreatedDate, Event: &e})
+		}
+	}
+	sort.SliceStable(items, func(a, b int) bool {
+		ta, _ := time.Parse(time.RFC3339Nano, items[a].Time)
+		tb, _ := time.Parse(time.RFC3339Nano, items[b].Time)
+		return ta.Before(tb)
+	})
+	return items
+}
+
+func paginate(n int, page string, limit string) (int, int) {
+	lim, err := strconv.Atoi(limit)
+	if err != nil || lim <= 0 {
+		lim = config.GetInt("Activity.limit")
+	}
+	if max := config.GetInt("Activity.max_limit"); max > 0 && lim > max {
+		lim = max
+	}
+	if lim <= 0 {
+		lim = 1
+	}
+	p, err := strconv.Atoi(page)
+	if err != nil || p < 1 {
+		p = 1
+	}
+	from := n
+	if p-1 <= n/lim {
+		from = (p - 1) * lim
+	}
+	if from > n {
+		from = n
+	}
+	to := n
+	if lim < n-from {
+		to = from + lim
+	}
+	return from, to
+}
+
+func commentsHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	log.WithFields(log.Fields{"method": r.Method, "url": r.URL.String(), "body": r.Body}).Info("commentsHandler started")
+	vars := mux.Vars(r)
+	if !containsTask(tasks, vars["id"]) {
+		http.NotFound(w, r)
+		return
+	}
+	cs := getTaskComments(vars["id"])
+	from, to := paginate(len(cs), r.URL.Query().Get("page"), r.URL.Query().Get("limit"))
+	err := json.NewEncoder(w).Encode(cs[from:to])
+	end := time.Now()
+	execTime := end.Sub(start)
+	if err != nil {
+		log.WithFields(log.Fields{"execution time": execTime}).Fatal("commentsHandler ended: " + err.Error())
+	}
+	log.WithFields(log.Fields{"execution time": execTime}).Info("commentsHandler ended")
+}
+
+func newCommentHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	log.WithFields(log.Fields{"method": r.Method, "url": r.URL.String(), "body": r.Body}).Info("newCommentHandler started")
+	vars := mux.Vars(r)
+	if !containsTask(tasks, vars["id"]) {
+		http.NotFound(w, r)
+		return
+	}
+	var c comment
+	err := json.NewDecoder(r.Body).Decode(&c)
+	if err != nil {
+		http.Error(w, "400 "+err.Error(), http.StatusBadRequest)
+		log.Error("Decoding comment from request body: ", err.Error())
+		return
+	}
+	if c.Text == "" {
+		http.Error(w, "400 text is not specified", http.StatusBadRequest)
+		log.Error("Comment text is not specified.")
+		return
+	}
+	last := 0
+	if len(comments) != 0 {
+		last = comments[len(comments)-1].CommentID
+	}
+	c.CommentID = nextID("comment", last)
+	c.TaskID = vars["id"]
+	c.Author = requestActor(r)
+	c.CreatedDate = time.Now().Format(time.RFC3339Nano)
+	c.UpdatedDate = ""
+	comments = append(comments, c)
+	err = json.NewEncoder(w).Encode(c)
+	end := time.Now()
+	execTime := end.Sub(start)
+	if err != nil {
+		log.WithFields(log.Fields{"execution time": execTime}).Fatal("newCommentHandler ended: " + err.Error())
+	}
+	log.WithFields(log.Fields{"execution time": execTime}).Info("newCommentHandler ended")
+}
+
+func commentEditHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	log.WithFields(log.Fields{"method": r.Method, "url": r.URL.String(), "body": r.Body}).Info("commentEditHandler started")
+	vars := mux.Vars(r)
+	ID, err := strconv.Atoi(vars["comment"])
+	n := getCommentNumByID(ID)
+	if err != nil || n < 0 || comments[n].TaskID != vars["id"] {
+		http.NotFound(w, r)
+		return
+	}
+	if comments[n].Author != requestActor(r) {
+		http.Error(w, "403 only the author can edit a comment", http.StatusForbidden)
+		log.WithField("Comment ID: ", ID).Warn("Comment edited not by its author.")
+		return
+	}
+	var c comment
+	err = json.NewDecoder(r.Body).Decode(&c)
+	if err != nil {
+		http.Error(w, "400 "+err.Error(), http.StatusBadRequest)
+		log.Error("Decoding comment from request body: ", err.Error())
+		return
+	}
+	if c.Text == "" {
+		http.Error(w, "400 text is not specified", http.StatusBadRequest)
+		log.Error("Comment text is not specified.")
+		return
+	}
+	comments[n].Text = c.Text
+	comments[n].UpdatedDate = time.Now().Format(time.RFC3339Nano)
+	err = json.NewEncoder(w).Encode(comments[n])
+	end := time.Now()
+	execTime := end.Sub(start)
+	if err != nil {
+		log.WithFields(log.Fields{"execution time": execTime}).Fatal("commentEditHandler ended: " + err.Error())
+	}
+	log.WithFields(log.Fields{"execution time": execTime}).Info("commentEditHandler ended")
+}
+
+func commentDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	log.WithFields(log.Fields{"method": r.Method, "url": r.URL.String(), "body": r.Body}).Info("commentDeleteHandler started")
+	vars := mux.Vars(r)
+	ID, err := strconv.Atoi(vars["comment"])
+	n := getCommentNumByID(ID)
+	if err != nil || n < 0 || comments[n].TaskID != vars["id"] {
+		http.NotFound(w, r)
+		return
+	}
+	if comments[n].Author != requestActor(r) {
+		http.Error(w, "403 only the author can delete a comment", http.StatusForbidden)
+		log.WithField("Comment ID: ", ID).Warn("Comment deleted not by its author.")
+		return
+	}
+	comments = append(comments[:n], comments[n+1:]...)
+	_, err = w.Write([]byte("comment deleted"))
+	end := time.Now()
+	execTime := end.Sub(start)
+	if err != nil {
+		log.WithFields(log.Fields{"execution time": execTime}).Fatal("commentDeleteHandler ended: " + err.Error())
+	}
+	log.WithFields(log.Fields{"execution time": execTime}).Info("commentDeleteHandler ended")
+}
+
+func activityHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	log.WithFields(log.Fields{"method": r.Method, "url": r.URL.String(), "body": r.Body}).Info("activityHandler started")
+	vars := mux.Vars(r)
+	if !containsTask(tasks, vars["id"]) {
+		http.NotFound(w, r)
+		return
+	}
+	items := getTaskActivity(vars["id"])
+	from, to := paginate(len(items), r.URL.Query().Get("page"), r.URL.Query().Get("limit"))
+	err := json.NewEncoder(w).Encode(append([]activityItem{}, items[from:to]...))
+	end := time.Now()
+	execTime := end.Sub(start)
+	if err != nil {
+		log.WithFields(log.Fields{"execution time": execTime}).Fatal("activityHandler ended: " + err.Error())
+	}
+	log.WithFields(log.Fields{"execution time": execTime}).Info("activityHandler ended")
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"math"
+	"strconv"
+	"testing"
+)
+
+func TestPaginate(t *testing.T) {
+	setTestConfig(t, config, "Activity.limit", 20)
+	setTestConfig(t, config, "Activity.max_limit", 200)
+	max := strconv.Itoa(math.MaxInt)
+	cases := []struct {
+		n        int
+		page     string
+		limit    string
+		from, to int
+	}{
+		{50, "", "", 0, 20},
+		{50, "3", "", 40, 50},
+		{50, "4", "", 50, 50},
+		{50, "2", "10", 10, 20},
+		{500, "1", max, 0, 200},
+		{500, "2", max, 200, 400},
+		{50, max, "2", 50, 50},
+		{50, max, max, 50, 50},
+		{50, "-1", "0", 0, 20},
+	}
+	for i := 0; i < len(cases); i++ {
+		c := cases[i]
+		from, to := paginate(c.n, c.page, c.limit)
+		if from != c.from || to != c.to {
+			t.Errorf("paginate(%d, %q, %q) = %d, %d, want %d, %d", c.n, c.page, c.limit, from, to, c.from, c.to)
+		}
+	}
+}
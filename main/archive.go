@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"strings"
+	"time"
+)
+
+func getUnarchivedTasks(ts []task) []task {
+	var newTasks []task
+	for i := 0; i < len(ts); i++ {
+		if !ts[i].Archived {
+			newTasks = append(newTasks, ts[i])
+		}
+	}
+	return newTasks
+}
+
+func archiveTask(ts []task, id string, archived bool) ([]task, error) {
+	n := getTaskNumByID(ts, id)
+	if ts[n].Archived == archived {
+		return ts, errors.New("already of this type")
+	}
+	if archived && !ts[n].Completed {
+		return ts, errors.New("only completed tasks can be archived")
+	}
+	ts[n].Archived = archived
+	if archived {
+		ts[n].ArchivedDate = time.Now().Format(time.RFC3339Nano)
+		logActivity(id, "archived", nil)
+	} else {
+		ts[n].ArchivedDate = ""
+		logActivity(id, "unarchived", nil)
+	}
+	return ts, nil
+}
+
+func archiveCompletedTasks(ts []task, now time.Time) []task {
+	days := config.GetInt("Archive.after_days")
+	for i := 0; i < len(ts); i++ {
+		if ts[i].Archived || !ts[i].Completed {
+			continue
+		}
+		completedDate, err := time.Parse(time.RFC3339Nano, ts[i].CompletedDate)
+		if err == nil && completedDate.AddDate(0, 0, days).Before(now) {
+			log.WithField("Task ID: ", ts[i].TaskID).Info("Archiving completed task.")
+			ts, _ = archiveTask(ts, ts[i].TaskID, true)
+		}
+	}
+	return ts
+}
+
+func runArchivePolicy() {
+	interval, err := time.ParseDuration(config.GetString("Archive.interval"))
+	if !config.GetBool("Archive.enabled") || err != nil || interval <= 0 {
+		log.Warn("Archive policy is disabled.")
+		return
+	}
+	for now := time.Now(); ; now = <-time.After(interval) {
+		storeMu.Lock()
+		forEachWorkspace(func() {
+			trackSystemChanges(func() {
+				tasks = archiveCompletedTasks(tasks, now)
+			})
+		})
+		storeMu.Unlock()
+	}
+}
+
+func archiveHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	log.WithFields(log.Fields{"method": r.Method, "url": r.URL.String(), "body": r.Body}).Info("archiveHandler started")
+	vars := mux.Vars(r)
+	if !containsTask(tasks, vars["id"]) {
+		http.NotFound(w, r)
+		return
+	}
+	var err error
+	mark := len(activity)
+	tasks, err = archiveTask(tasks, vars["id"], strings.HasSuffix(r.URL.Path, "/archive"))
+	if err != nil {
+		http.Error(w, "400 "+err.Error(), http.StatusBadRequest)
+		log.WithField("Task ID: ", vars["id"]).Warn("Task is ", err.Error())
+		return
+	}
+	setActivityActor(mark, requestActor(r))
+	err = json.NewEncoder(w).Encode(decorateTask(tasks, tasks[getTaskNumByID(tasks, vars["id"])]))
+	end := time.Now()
+	execTime := end.Sub(start)
+	if err != nil {
+		log.WithFields(log.Fields{"execution time": execTime}).Fatal("archiveHandler ended: " + err.Error())
+	}
+	log.WithFields(log.Fields{"execution time": execTime}).Info("archiveHandler ended")
+}
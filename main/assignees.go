@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"time"
+)
+
+const unassigned = "unassigned"
+
+func validateAssignees(ids []string, current []string) error {
+	authMu.Lock()
+	defer authMu.Unlock()
+	for i := 0; i < len(ids); i++ {
+		if containsString(ids[:i], ids[i]) {
+			return errors.New("assignee " + ids[i] + " is specified twice")
+		}
+		if containsString(current, ids[i]) {
+			continue
+		}
+		n := getUserNum(users, ids[i])
+		if n < 0 || !canAccessWorkspace(users[n], activeWorkspace) {
+			return errors.New("assignee " + ids[i] + " does not exist")
+		}
+	}
+	return nil
+}
+
+func logAssigneeChanges(id string, oldIDs []string, newIDs []string) {
+	for i := 0; i < len(oldIDs); i++ {
+		if !containsString(newIDs, oldIDs[i]) {
+			logActivity(id, "unassigned", map[string]string{"assignee": oldIDs[i]})
+		}
+	}
+	for i := 0; i < len(newIDs); i++ {
+		if !containsString(oldIDs, newIDs[i]) {
+			logActivity(id, "assigned", map[string]string{"assignee": newIDs[i]})
+		}
+	}
+}
+
+func getAssignedTasks(ts []task, username string) []task {
+	var newTasks []task
+	for i := 0; i < len(ts); i++ {
+		if containsString(ts[i].AssigneeIDs, username) {
+			newTasks = append(newTasks, ts[i])
+		}
+	}
+	return newTasks
+}
+
+func getUnassignedTasks(ts []task) []task {
+	var newTasks []task
+	for i := 0; i < len(ts); i++ {
+		if len(ts[i].AssigneeIDs) == 0 {
+			newTasks = append(newTasks, ts[i])
+		}
+	}
+	return newTasks
+}
+
+func unassignUser(ts []task, username string) []task {
+	for i := 0; i < len(ts); i++ {
+		if !containsString(ts[i].AssigneeIDs, username) {
+			continue
+		}
+		var ids []string
+		for j := 0; j < len(ts[i].AssigneeIDs); j++ {
+			if ts[i].AssigneeIDs[j] != username {
+				ids = append(ids, ts[i].AssigneeIDs[j])
+			}
+		}
+		ts[i].AssigneeIDs = ids
+		logActivity(ts[i].TaskID, "unassigned", map[string]string{"assignee": username})
+	}
+	return ts
+}
+
+func addAssigneeStat(s *statistics, ts []task, period string) error {
+	s.ByAssignee = map[string]statistics{}
+	var names []string
+	for i := 0; i < len(ts); i++ {
+		for j := 0; j < len(ts[i].AssigneeIDs); j++ {
+			if !containsString(names, ts[i].AssigneeIDs[j]) {
+				names = append(names, ts[i].AssigneeIDs[j])
+			}
+		}
+	}
+	for i := 0; i < len(names); i++ {
+		stat, err := getStat(getAssignedTasks(ts, names[i]), period)
+		if err != nil {
+			return err
+		}
+		s.ByAssignee[names[i]] = stat
+	}
+	stat, err := getStat(getUnassignedTasks(ts), period)
+	if err != nil {
+		return err
+	}
+	s.ByAssignee[unassigned] = stat
+	return nil
+}
+
+func myTasksHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	l := r.URL.Query().Get("limit")
+	s := r.URL.Query().Get("sort")
+	t := r.URL.Query().Get("type")
+	log.WithFields(log.Fields{"method": r.Method, "url": r.URL.String(), "params": log.Fields{"limit": l, "sort": s, "type": t}}).Info("myTasksHandler started")
+	u, _ := requestUser(r)
+	newTasks := getAssignedTasks(tasks, u.Username)
+	if r.URL.Query().Get("include_archived") != "true" {
+		newTasks = getUnarchivedTasks(newTasks)
+	}
+	newTasks = getSortedTasks(newTasks, s, l, t)
+	err := json.NewEncoder(w).Encode(decorateTasks(tasks, newTasks))
+	end := time.Now()
+	execTime := end.Sub(start)
+	if err != nil {
+		log.WithFields(log.Fields{"execution time": execTime}).Fatal("myTasksHandler ended: " + err.Error())
+	}
+	log.WithFields(log.Fields{"execution time": execTime}).Info("myTasksHandler ended")
+}
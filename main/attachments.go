@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+type attachment struct {
+	AttachmentID int    `json:"attachment_id"`
+	TaskID       string `json:"task_id"`
+	Name         string `json:"name"`
+	MimeType     string `json:"mime_type"`
+	Size         int64  `json:"size"`
+	Hash         string `json:"sha256"`
+	Author       string `json:"author"`
+	CreatedDate  string `json:"created_at"`
+}
+
+var attachments = readAttachments()
+
+var errFileTooLarge = errors.New("file is too large")
+
+func readAttachments() []attachment {
+	var as []attachment
+	readJSONFile("attachments.json", &as)
+	return as
+}
+
+func blobPath(hash string) string {
+	return filepath.Join(config.GetString("Attachments.dir"), hash[:2], hash)
+}
+
+func getAttachmentNumByID(id int) int {
+	for i := 0; i < len(attachments); i++ {
+		if attachments[i].AttachmentID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func getTaskAttachments(id string) []attachment {
+	as := []attachment{}
+	for i := 0; i < len(attachments); i++ {
+		if attachments[i].TaskID == id {
+			as = append(as, attachments[i])
+		}
+	}
+	return as
+}
+
+func storeBlob(src io.Reader, maxSize int64) (string, int64, string, error) {
+	dir := config.GetString("Attachments.dir")
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return "", 0, "", err
+	}
+	tmp, err := ioutil.TempFile(dir, "upload-")
+	if err != nil {
+		return "", 0, "", err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	buf := bufio.NewReader(src)
+	head, _ := buf.Peek(512)
+	mimeType := http.DetectContentType(head)
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, hash), io.LimitReader(buf, maxSize+1))
+	if err != nil {
+		return "", 0, "", err
+	}
+	if size > maxSize {
+		return "", 0, "", errFileTooLarge
+	}
+	sum := hex.EncodeToString(hash.Sum(nil))
+	if _, err = os.Stat(blobPath(sum)); err == nil {
+		return sum, size, mimeType, nil
+	}
+	err = os.MkdirAll(filepath.Dir(blobPath(sum)), 0755)
+	if err != nil {
+		return "", 0, "", err
+	}
+	err = tmp.Close()
+	if err != nil {
+		return "", 0, "", err
+	}
+	return sum, size, mimeType, os.Rename(tmp.Name(), blobPath(sum))
+}
+
+func renameAttachmentTask(oldID string, newID string) {
+	for i := 0; i < len(attachments); i++ {
+		if attachments[i].TaskID == oldID {
+			attachments[i].TaskID = newID
+		}
+	}
+}
+
+func removeTaskAttachments(id string) {
+	var as []attachment
+	for i := 0; i < len(attachments); i++ {
+		if attachments[i].TaskID != id {
+			as = append(as, attachments[i])
+		}
+	}
+	attachments = as
+	gcBlobs()
+}
+
+func gcBlobs() {
+	used := map[string]bool{}
+	for i := 0; i < len(attachments); i++ {
+		used[attachments[i].Hash] = true
+	}
+	dir := config.GetString("Attachments.dir")
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || filepath.Dir(path) == filepath.Clean(dir) || used[info.Name()] {
+			return nil
+		}
+		log.WithField("Blob: ", info.Name()).Info("Removing orphaned attachment blob.")
+		return os.Remove(path)
+	})
+	if err != nil && !os.IsNotExist(err) {
+		log.Error("Attachments garbage collection: ", err.Error())
+	}
+}
+
+func attachmentsHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	log.WithFields(log.Fields{"method": r.Method, "url": r.URL.String(), "body": r.Body}).Info("attachmentsHandler started")
+	vars := mux.Vars(r)
+	if !containsTask(tasks, vars["id"]) {
+		http.NotFound(w, r)
+		return
+	}
+	err := json.NewEncoder(w).Encode(getTaskAttachments(vars["id"]))
+	end := time.Now()
+	execTime := end.Sub(start)
+	if err != nil {
+		log.WithFields(log.Fields{"execution time": execTime}).Fatal("attachmentsHandler ended: " + err.Error())
+	}
+	log.WithFields(log.Fields{"execution time": execTime}).Info("attachmentsHandler ended")
+}
+
+func newAttachmentHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	log.WithFields(log.Fields{"method": r.Method, "url": r.URL.String()}).Info("newAttachmentHandler started")
+	vars := mux.Vars(r)
+	if !containsTask(tasks, vars["id"]) {
+		http.NotFound(w, r)
+		return
+	}
+	maxSize := config.GetInt64("Attachments.max_size")
+	r.Body = http.MaxBytesReader(w, r.Body, maxSize+1<<20)
+	reader, err := r.MultipartReader()
+	if err != nil {
+		http.Error(w, "400 "+err.Error(), http.StatusBadRequest)
+		log.Error("Reading multipart body: ", err.Error())
+		return
+	}
+	var part io.ReadCloser
+	var name string
+	for {
+		p, err := reader.NextPart()
+		if err != nil {
+			http.Error(w, "400 file is not specified", http.StatusBadRequest)
+			log.Error("Attachment file is not specified.")
+			return
+		}
+		if p.FormName() == "file" && p.FileName() != "" {
+			part, name = p, filepath.Base(p.FileName())
+			break
+		}
+		p.Close()
+	}
+	defer part.Close()
+	hash, size, mimeType, err := storeBlob(part, maxSize)
+	if err != nil {
+		code := http.StatusBadRequest
+		var maxBytesErr *http.MaxBytesError
+		if err == errFileTooLarge || errors.As(err, &maxBytesErr) {
+			code = http.StatusRequestEntityTooLarge
+		}
+		http.Error(w, strconv.Itoa(code)+" "+err.Error(), code)
+		log.Error("Storing attachment: ", err.Error())
+		return
+	}
+	a := attachment{
+		TaskID:      vars["id"],
+		Name:        name,
+		MimeType:    mimeType,
+		Size:        size,
+		Hash:        hash,
+		Author:      requestActor(r),
+		CreatedDate: time.Now().Format(time.RFC3339Nano),
+	}
+	last := 0
+	if len(attachments) != 0 {
+		last = attachments[len(attachments)-1].AttachmentID
+	}
+	a.AttachmentID = nextID("attachment", last)
+	attachments = append(attachments, a)
+	err = json.NewEncoder(w).Encode(a)
+	end := time.Now()
+	execTime := end.Sub(start)
+	if err != nil {
+		log.WithFields(log.Fields{"execution time": execTime}).Fatal("newAttachmentHandler ended: " + err.Error())
+	}
+	log.WithFields(log.Fields{"execution time": execTime}).Info("newAttachmentHandler ended")
+}
+
+func attachmentDownloadHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	log.WithFields(log.Fields{"method": r.Method, "url": r.URL.String(), "range": r.Header.Get("Range")}).Info("attachmentDownloadHandler started")
+	vars := mux.Vars(r)
+	ID, err := strconv.Atoi(vars["attachment"])
+	n := getAttachmentNumByID(ID)
+	if err != nil || n < 0 || attachments[n].TaskID != vars["id"] {
+		http.NotFound(w, r)
+		return
+	}
+	a := attachments[n]
+	file, err := os.Open(blobPath(a.Hash))
+	if err != nil {
+		http.Error(w, "500 attachment content is missing", http.StatusInternalServerError)
+		log.Error("Opening attachment blob: ", err.Error())
+		return
+	}
+	modTime, _ := time.Parse(time.RFC3339Nano, a.CreatedDate)
+	w.Header().Set("Content-Type", a.MimeType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": a.Name}))
+	w.Header().Set("ETag", fmt.Sprintf("%q", a.Hash))
+	deferResponse(w, func(w http.ResponseWriter) {
+		defer file.Close()
+		http.ServeContent(w, r, a.Name, modTime, file)
+	})
+	end := time.Now()
+	execTime := end.Sub(start)
+	log.WithFields(log.Fields{"execution time": execTime}).Info("attachmentDownloadHandler ended")
+}
+
+func attachmentDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	log.WithFields(log.Fields{"method": r.Method, "url": r.URL.String(), "body": r.Body}).Info("attachmentDeleteHandler started")
+	vars := mux.Vars(r)
+	ID, err := strconv.Atoi(vars["attachment"])
+	n := getAttachmentNumByID(ID)
+	if err != nil || n < 0 || attachments[n].TaskID != vars["id"] {
+		http.NotFound(w, r)
+		return
+	}
+	attachments = append(attachments[:n], attachments[n+1:]...)
+	gcBlobs()
+	_, err = w.Write([]byte("attachment deleted"))
+	end := time.Now()
+	execTime := end.Sub(start)
+	if err != nil {
+		log.WithFields(log.Fields{"execution time": execTime}).Fatal("attachmentDeleteHandler ended: " + err.Error())
+	}
+	log.WithFields(log.Fields{"execution time": execTime}).Info("attachmentDeleteHandler ended")
+}
@@ -0,0 +1,433 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	"net"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type auditEntry struct {
+	AuditID     int                    `json:"audit_id"`
+	RequestID   string                 `json:"request_id"`
+	Workspace   string                 `json:"workspace"`
+	Actor       string                 `json:"actor"`
+	ClientIP    string                 `json:"client_ip"`
+	Method      string                 `json:"method"`
+	Route       string                 `json:"route"`
+	Path        string                 `json:"path"`
+	Status      int                    `json:"status"`
+	Action      string                 `json:"action"`
+	Resource    string                 `json:"resource,omitempty"`
+	ResourceID  string                 `json:"resource_id,omitempty"`
+	Changes     map[string]fieldChange `json:"changes,omitempty"`
+	Before      json.RawMessage        `json:"before,omitempty"`
+	After       json.RawMessage        `json:"after,omitempty"`
+	Suppressed  int                    `json:"suppressed,omitempty"`
+	CreatedDate string                 `json:"created_at"`
+}
+
+type rejectedAudit struct {
+	entry auditEntry
+	since time.Time
+	count int
+}
+
+const requestIDKey contextKey = "request_id"
+
+var requestIDPattern = regexp.MustCompile(`^[a-zA-Z0-9._-]{1,64}$`)
+
+var auditMu sync.Mutex
+
+var auditLog = readAudit()
+
+var rejectedMu sync.Mutex
+
+var rejectedAudits = map[string]*rejectedAudit{}
+
+var routeVar = regexp.MustCompile(`{([a-zA-Z0-9_]+)(:[^}]*)?}`)
+
+func readAudit() []auditEntry {
+	var entries []auditEntry
+	file, err := os.Open(baseConfig.GetString("Audit.file"))
+	if os.IsNotExist(err) {
+		return entries
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 16*1024*1024)
+	for scanner.Scan() {
+		var e auditEntry
+		err = json.Unmarshal(scanner.Bytes(), &e)
+		if err != nil {
+			log.Fatal("Reading audit log: ", err)
+		}
+		entries = append(entries, e)
+		if limit := baseConfig.GetInt("Audit.memory_limit"); limit > 0 && len(entries) > limit {
+			entries = entries[len(entries)-limit:]
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		log.Fatal(err)
+	}
+	return entries
+}
+
+func appendAudit(entries []auditEntry) {
+	if len(entries) == 0 {
+		return
+	}
+	auditMu.Lock()
+	defer auditMu.Unlock()
+	name := baseConfig.GetString("Audit.file")
+	if info, err := os.Stat(name); err == nil && baseConfig.GetInt64("Audit.max_size") > 0 && info.Size() >= baseConfig.GetInt64("Audit.max_size") {
+		err = os.Rename(name, name+".1")
+		if err != nil {
+			log.Error("Rotating audit log: ", err.Error())
+		}
+	}
+	file, err := os.OpenFile(name, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer file.Close()
+	for i := 0; i < len(entries); i++ {
+		entries[i].AuditID = 1
+		if len(auditLog) != 0 {
+			entries[i].AuditID = auditLog[len(auditLog)-1].AuditID + 1
+		}
+		line, err := json.Marshal(entries[i])
+		if err != nil {
+			log.Fatal(err)
+		}
+		_, err = file.Write(append(line, '\n'))
+		if err != nil {
+			log.Fatal(err)
+		}
+		auditLog = append(auditLog, entries[i])
+	}
+	if limit := baseConfig.GetInt("Audit.memory_limit"); limit > 0 && len(auditLog) > limit {
+		auditLog = auditLog[len(auditLog)-limit:]
+	}
+}
+
+func getRequestID(id string) string {
+	if requestIDPattern.MatchString(id) {
+		return id
+	}
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func requestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := getRequestID(r.Header.Get("X-Request-ID"))
+		w.Header().Set("X-Request-ID", id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey, id)))
+	})
+}
+
+func requestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDKey).(string)
+	return id
+}
+
+func clientIP(r *http.Request) string {
+	if baseConfig.GetBool("Audit.trust_forwarded") {
+		if f := r.Header.Get("X-Forwarded-For"); f != "" {
+			return strings.TrimSpace(strings.Split(f, ",")[0])
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func getRouteResource(template string, vars map[string]string) (string, string) {
+	segments := strings.Split(strings.Trim(template, "/"), "/")
+	resource := ""
+	static := ""
+	var ids []string
+	for i := 0; i < len(segments); i++ {
+		m := routeVar.FindStringSubmatch(segments[i])
+		if m == nil {
+			if static == "" || static == "me" || len(ids) != 0 {
+				static = segments[i]
+			}
+			continue
+		}
+		if m[1] != "workspace" {
+			resource = static
+			ids = append(ids, vars[m[1]])
+		}
+	}
+	if len(ids) == 0 {
+		resource = static
+	}
+	switch {
+	case strings.HasSuffix(resource, "ies"):
+		resource = strings.TrimSuffix(resource, "ies") + "y"
+	case strings.HasSuffix(resource, "s"):
+		resource = strings.TrimSuffix(resource, "s")
+	}
+	return resource, strings.Join(ids, "/")
+}
+
+func newAuditEntry(r *http.Request, workspace string, status int) auditEntry {
+	e := auditEntry{
+		RequestID:   requestID(r),
+		Workspace:   workspace,
+		Actor:       requestActor(r),
+		ClientIP:    clientIP(r),
+		Method:      r.Method,
+		Route:       getRouteTemplate(r),
+		Path:        r.URL.Path,
+		Status:      status,
+		Action:      strings.ToLower(r.Method),
+		CreatedDate: time.Now().Format(time.RFC3339Nano),
+	}
+	if e.Route == "" {
+		e.Route = r.URL.Path
+	}
+	e.Resource, e.ResourceID = getRouteResource(e.Route, mux.Vars(r))
+	return e
+}
+
+func getRejectedInterval() time.Duration {
+	interval, err := time.ParseDuration(baseConfig.GetString("Audit.rejected_interval"))
+	if err != nil || interval < 0 {
+		return time.Minute
+	}
+	return interval
+}
+
+func getSuppressedEntry(ra *rejectedAudit) auditEntry {
+	e := ra.entry
+	e.RequestID = ""
+	e.Suppressed = ra.count
+	e.CreatedDate = time.Now().Format(time.RFC3339Nano)
+	return e
+}
+
+func pruneRejectedAudits(now time.Time, interval time.Duration) []auditEntry {
+	var entries []auditEntry
+	for key, ra := range rejectedAudits {
+		if now.Sub(ra.since) < interval {
+			continue
+		}
+		if ra.count != 0 {
+			entries = append(entries, getSuppressedEntry(ra))
+		}
+		delete(rejectedAudits, key)
+	}
+	return entries
+}
+
+func auditRejected(r *http.Request, workspace string, status int) {
+	if mux.Vars(r)["workspace"] != "" {
+		workspace = mux.Vars(r)["workspace"]
+	}
+	if workspace == "" {
+		workspace = defaultWorkspace
+	}
+	e := newAuditEntry(r, workspace, status)
+	interval := getRejectedInterval()
+	if interval == 0 {
+		appendAudit([]auditEntry{e})
+		return
+	}
+	now := time.Now()
+	key := e.ClientIP + " " + strconv.Itoa(status)
+	rejectedMu.Lock()
+	var entries []auditEntry
+	if len(rejectedAudits) >= 10000 {
+		entries = pruneRejectedAudits(now, interval)
+	}
+	ra := rejectedAudits[key]
+	if ra != nil && now.Sub(ra.since) < interval {
+		ra.count++
+		rejectedMu.Unlock()
+		appendAudit(entries)
+		return
+	}
+	if ra != nil {
+		e.Suppressed = ra.count
+	}
+	rejectedAudits[key] = &rejectedAudit{entry: e, since: now}
+	rejectedMu.Unlock()
+	appendAudit(append(entries, e))
+}
+
+func flushRejectedAudits() {
+	rejectedMu.Lock()
+	var entries []auditEntry
+	for key, ra := range rejectedAudits {
+		if ra.count != 0 {
+			entries = append(entries, getSuppressedEntry(ra))
+		}
+		delete(rejectedAudits, key)
+	}
+	rejectedMu.Unlock()
+	appendAudit(entries)
+}
+
+func auditRequest(r *http.Request, status int, changes []recordChange) {
+	base := newAuditEntry(r, activeWorkspace, status)
+	if len(changes) == 0 {
+		appendAudit([]auditEntry{base})
+		return
+	}
+	appendAudit(getChangeAuditEntries(base, changes))
+}
+
+func auditSystem(changes []recordChange) {
+	if len(changes) == 0 {
+		return
+	}
+	base := auditEntry{
+		Workspace:   activeWorkspace,
+		Actor:       "system",
+		Status:      http.StatusOK,
+		CreatedDate: time.Now().Format(time.RFC3339Nano),
+	}
+	appendAudit(getChangeAuditEntries(base, changes))
+}
+
+func getChangeAuditEntries(base auditEntry, changes []recordChange) []auditEntry {
+	entries := make([]auditEntry, 0, len(changes))
+	for i := 0; i < len(changes); i++ {
+		e := base
+		e.Resource = changes[i].Resource
+		e.Before = changes[i].Before
+		e.After = changes[i].After
+		switch {
+		case changes[i].Before == nil:
+			e.Action = "created"
+			e.ResourceID = changes[i].AfterID
+		case changes[i].After == nil:
+			e.Action = "deleted"
+			e.ResourceID = changes[i].BeforeID
+		default:
+			e.Action = "updated"
+			e.ResourceID = changes[i].AfterID
+			e.Changes = diffRecords(changes[i].Before, changes[i].After)
+		}
+		if changes[i].Action != "" {
+			e.Action = changes[i].Action
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+func filterAudit(r *http.Request) ([]auditEntry, error) {
+	q := r.URL.Query()
+	var since, until time.Time
+	var err error
+	if s := q.Get("since"); s != "" {
+		if since, err = time.Parse(time.RFC3339Nano, s); err != nil {
+			return nil, errors.New("since must be in RFC3339 format")
+		}
+	}
+	if s := q.Get("until"); s != "" {
+		if until, err = time.Parse(time.RFC3339Nano, s); err != nil {
+			return nil, errors.New("until must be in RFC3339 format")
+		}
+	}
+	status := 0
+	if s := q.Get("status"); s != "" {
+		if status, err = strconv.Atoi(s); err != nil {
+			return nil, errors.New("status must be a number")
+		}
+	}
+	fields := []string{"actor", "action", "resource", "resource_id", "request_id", "workspace", "method"}
+	entries := []auditEntry{}
+	auditMu.Lock()
+	defer auditMu.Unlock()
+	for i := 0; i < len(auditLog); i++ {
+		e := auditLog[i]
+		values := map[string]string{"actor": e.Actor, "action": e.Action, "resource": e.Resource, "resource_id": e.ResourceID,
+			"request_id": e.RequestID, "workspace": e.Workspace, "method": e.Method}
+		matched := status == 0 || status == e.Status
+		for j := 0; j < len(fields) && matched; j++ {
+			if v := q.Get(fields[j]); v != "" && !strings.EqualFold(v, values[fields[j]]) {
+				matched = false
+			}
+		}
+		created, _ := time.Parse(time.RFC3339Nano, e.CreatedDate)
+		if !matched || (!since.IsZero() && created.Before(since)) || (!until.IsZero() && !created.Before(until)) {
+			continue
+		}
+		entries = append(entries, e)
+	}
+	lim, err := strconv.Atoi(q.Get("limit"))
+	if err == nil && lim >= 0 && lim < len(entries) {
+		entries = entries[len(entries)-lim:]
+	}
+	return entries, nil
+}
+
+func auditHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	log.WithFields(log.Fields{"method": r.Method, "url": r.URL.String()}).Info("auditHandler started")
+	if !requireAdmin(w, r) {
+		return
+	}
+	entries, err := filterAudit(r)
+	if err != nil {
+		http.Error(w, "400 "+err.Error(), http.StatusBadRequest)
+		log.Error("Filtering audit log: ", err.Error())
+		return
+	}
+	err = json.NewEncoder(w).Encode(entries)
+	end := time.Now()
+	execTime := end.Sub(start)
+	if err != nil {
+		log.WithFields(log.Fields{"execution time": execTime}).Fatal("auditHandler ended: " + err.Error())
+	}
+	log.WithFields(log.Fields{"execution time": execTime}).Info("auditHandler ended")
+}
+
+func auditExportHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	log.WithFields(log.Fields{"method": r.Method, "url": r.URL.String()}).Info("auditExportHandler started")
+	if !requireAdmin(w, r) {
+		return
+	}
+	entries, err := filterAudit(r)
+	if err != nil {
+		http.Error(w, "400 "+err.Error(), http.StatusBadRequest)
+		log.Error("Filtering audit log: ", err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Content-Disposition", `attachment; filename="audit.jsonl"`)
+	enc := json.NewEncoder(w)
+	for i := 0; i < len(entries) && err == nil; i++ {
+		err = enc.Encode(entries[i])
+	}
+	end := time.Now()
+	execTime := end.Sub(start)
+	if err != nil {
+		log.WithFields(log.Fields{"execution time": execTime}).Fatal("auditExportHandler ended: " + err.Error())
+	}
+	log.WithFields(log.Fields{"execution time": execTime}).Info("auditExportHandler ended")
+}
@@ -0,0 +1,496 @@
+package main
+
+import (
+	"context"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type user struct {
+	Username     string   `json:"username"`
+	PasswordHash string   `json:"password_hash,omitempty"`
+	Admin        bool     `json:"admin"`
+	Workspaces   []string `json:"workspaces,omitempty"`
+	CreatedDate  string   `json:"created_at"`
+}
+
+type credentials struct {
+	Username   string   `json:"username"`
+	Password   string   `json:"password"`
+	Admin      bool     `json:"admin"`
+	Workspaces []string `json:"workspaces"`
+}
+
+type session struct {
+	Token     string `json:"token"`
+	Username  string `json:"username"`
+	ExpiresAt string `json:"expires_at"`
+	expires   time.Time
+}
+
+type contextKey string
+
+const userKey contextKey = "user"
+
+const scopesKey contextKey = "scopes"
+
+const passwordIterations = 600000
+
+var authMu sync.Mutex
+
+var users = readUsers()
+
+var sessions = map[string]session{}
+
+var dummyPasswordHash = newDummyPasswordHash()
+
+func readUsers() []user {
+	var us []user
+	readJSONFile("users.json", &us)
+	return us
+}
+
+func hashPassword(password string) (string, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key, err := pbkdf2.Key(sha256.New, password, salt, passwordIterations, 32)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("pbkdf2-sha256$%d$%s$%s", passwordIterations,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+func newDummyPasswordHash() string {
+	b := make([]byte, 48)
+	if _, err := rand.Read(b); err != nil {
+		log.Fatal(err)
+	}
+	return fmt.Sprintf("pbkdf2-sha256$%d$%s$%s", passwordIterations,
+		base64.RawStdEncoding.EncodeToString(b[:16]), base64.RawStdEncoding.EncodeToString(b[16:]))
+}
+
+func checkPassword(hash string, password string) bool {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 4 || parts[0] != "pbkdf2-sha256" {
+		return false
+	}
+	iter, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return false
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return false
+	}
+	expected, err := base64.RawStdEncoding.DecodeString(parts[3])
+	if err != nil {
+		return false
+	}
+	key, err := pbkdf2.Key(sha256.New, password, salt, iter, len(expected))
+	return err == nil && subtle.ConstantTimeCompare(key, expected) == 1
+}
+
+func getUserNum(us []user, username string) int {
+	for i := 0; i < len(us); i++ {
+		if us[i].Username == username {
+			return i
+		}
+	}
+	return -1
+}
+
+func addUser(us []user, c credentials, hash string) ([]user, error) {
+	if c.Username == "" || c.Password == "" {
+		return us, errors.New("username and password must be specified")
+	}
+	if strings.ContainsAny(c.Username, " /") {
+		return us, errors.New("username can not contain spaces or slashes")
+	}
+	if getUserNum(us, c.Username) >= 0 {
+		return us, errors.New("user with this name already exists")
+	}
+	if err := validateWorkspaceNames(c.Workspaces); err != nil {
+		return us, err
+	}
+	return append(us, user{Username: c.Username, PasswordHash: hash, Admin: c.Admin, Workspaces: c.Workspaces, CreatedDate: time.Now().Format(time.RFC3339Nano)}), nil
+}
+
+func addBootstrapAdmin() {
+	if len(users) != 0 {
+		return
+	}
+	c := credentials{
+		Username: config.GetString("Auth.admin_username"),
+		Password: config.GetString("Auth.admin_password"),
+		Admin:    true,
+	}
+	generated := c.Password == ""
+	if generated {
+		secret := make([]byte, 12)
+		if _, err := rand.Read(secret); err != nil {
+			log.Fatal("Generating bootstrap admin password: ", err.Error())
+		}
+		c.Password = base64.RawURLEncoding.EncodeToString(secret)
+	}
+	hash, err := hashPassword(c.Password)
+	if err != nil {
+		log.Fatal("Creating bootstrap admin: ", err.Error())
+	}
+	users, err = addUser(users, c, hash)
+	if err != nil {
+		log.Fatal("Creating bootstrap admin: ", err.Error())
+	}
+	if generated {
+		log.WithFields(log.Fields{"Username: ": c.Username, "Password: ": c.Password}).Warn("Bootstrap admin created with a generated password. Change it after the first login.")
+		return
+	}
+	log.WithField("Username: ", c.Username).Warn("Bootstrap admin created. Change its password.")
+}
+
+func newSession(username string) (session, error) {
+	token := make([]byte, 32)
+	if _, err := rand.Read(token); err != nil {
+		return session{}, err
+	}
+	ttl, err := time.ParseDuration(baseConfig.GetString("Auth.session_ttl"))
+	if err != nil || ttl <= 0 {
+		ttl = 24 * time.Hour
+	}
+	s := session{Token: hex.EncodeToString(token), Username: username, expires: time.Now().Add(ttl)}
+	s.ExpiresAt = s.expires.Format(time.RFC3339)
+	sessions[s.Token] = s
+	return s, nil
+}
+
+func removeUserSessions(username string) {
+	for token, s := range sessions {
+		if s.Username == username {
+			delete(sessions, token)
+		}
+	}
+}
+
+func getBearerToken(r *http.Request) string {
+	h := r.Header.Get("Authorization")
+	if !strings.HasPrefix(h, "Bearer ") {
+		if websocket.IsWebSocketUpgrade(r) {
+			return r.URL.Query().Get("access_token")
+		}
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimPrefix(h, "Bearer "))
+}
+
+func getLogURL(r *http.Request) string {
+	u := *r.URL
+	q := u.Query()
+	if q.Has("access_token") {
+		q.Set("access_token", "REDACTED")
+		u.RawQuery = q.Encode()
+	}
+	return u.String()
+}
+
+func authenticate(r *http.Request) (user, []string, string, bool) {
+	return authenticateBearer(getBearerToken(r))
+}
+
+func authenticateBearer(token string) (user, []string, string, bool) {
+	authMu.Lock()
+	defer authMu.Unlock()
+	if strings.HasPrefix(token, tokenPrefix) {
+		return authenticateToken(token)
+	}
+	s, ok := sessions[token]
+	if !ok {
+		return user{}, nil, "", false
+	}
+	n := getUserNum(users, s.Username)
+	if n < 0 || time.Now().After(s.expires) {
+		delete(sessions, s.Token)
+		return user{}, nil, "", false
+	}
+	return users[n], nil, "", true
+}
+
+func authMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/login" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		u, scopes, workspace, ok := authenticate(r)
+		if !ok {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "401 unauthorized", http.StatusUnauthorized)
+			log.WithFields(log.Fields{"method": r.Method, "url": getLogURL(r)}).Warn("Unauthenticated request.")
+			auditRejected(r, "", http.StatusUnauthorized)
+			return
+		}
+		if !checkScopes(r, scopes) {
+			http.Error(w, "403 token scope does not allow this request", http.StatusForbidden)
+			log.WithFields(log.Fields{"method": r.Method, "url": getLogURL(r), "scopes": scopes}).Warn("Insufficient token scope.")
+			auditRejected(r.WithContext(context.WithValue(r.Context(), userKey, u)), workspace, http.StatusForbidden)
+			return
+		}
+		ctx := context.WithValue(r.Context(), userKey, u)
+		if scopes != nil {
+			ctx = context.WithValue(ctx, scopesKey, scopes)
+		}
+		if workspace != "" {
+			ctx = context.WithValue(ctx, workspaceKey, workspace)
+		}
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func requestUser(r *http.Request) (user, bool) {
+	u, ok := r.Context().Value(userKey).(user)
+	return u, ok
+}
+
+func loginHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	log.WithFields(log.Fields{"method": r.Method, "url": r.URL.String()}).Info("loginHandler started")
+	var c credentials
+	err := json.NewDecoder(r.Body).Decode(&c)
+	if err != nil {
+		http.Error(w, "400 "+err.Error(), http.StatusBadRequest)
+		log.Error("Decoding credentials from request body: ", err.Error())
+		return
+	}
+	authMu.Lock()
+	hash := dummyPasswordHash
+	if n := getUserNum(users, c.Username); n >= 0 {
+		hash = users[n].PasswordHash
+	}
+	authMu.Unlock()
+	valid := checkPassword(hash, c.Password)
+	authMu.Lock()
+	defer authMu.Unlock()
+	n := getUserNum(users, c.Username)
+	if !valid || n < 0 || users[n].PasswordHash != hash {
+		http.Error(w, "401 invalid username or password", http.StatusUnauthorized)
+		log.WithField("Username: ", c.Username).Warn("Failed login.")
+		return
+	}
+	s, err := newSession(c.Username)
+	if err != nil {
+		http.Error(w, "500 "+err.Error(), http.StatusInternalServerError)
+		log.Error("Creating session: ", err.Error())
+		return
+	}
+	err = json.NewEncoder(w).Encode(s)
+	end := time.Now()
+	execTime := end.Sub(start)
+	if err != nil {
+		log.WithFields(log.Fields{"execution time": execTime}).Fatal("loginHandler ended: " + err.Error())
+	}
+	log.WithFields(log.Fields{"execution time": execTime}).Info("loginHandler ended")
+}
+
+func logoutHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	log.WithFields(log.Fields{"method": r.Method, "url": r.URL.String()}).Info("logoutHandler started")
+	authMu.Lock()
+	delete(sessions, getBearerToken(r))
+	authMu.Unlock()
+	_, err := fmt.Fprint(w, "logged out")
+	end := time.Now()
+	execTime := end.Sub(start)
+	if err != nil {
+		log.WithFields(log.Fields{"execution time": execTime}).Fatal("logoutHandler ended: " + err.Error())
+	}
+	log.WithFields(log.Fields{"execution time": execTime}).Info("logoutHandler ended")
+}
+
+func meHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	log.WithFields(log.Fields{"method": r.Method, "url": r.URL.String()}).Info("meHandler started")
+	u, _ := requestUser(r)
+	u.PasswordHash = ""
+	err := json.NewEncoder(w).Encode(u)
+	end := time.Now()
+	execTime := end.Sub(start)
+	if err != nil {
+		log.WithFields(log.Fields{"execution time": execTime}).Fatal("meHandler ended: " + err.Error())
+	}
+	log.WithFields(log.Fields{"execution time": execTime}).Info("meHandler ended")
+}
+
+func passwordHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	log.WithFields(log.Fields{"method": r.Method, "url": r.URL.String()}).Info("passwordHandler started")
+	var c struct {
+		Old string `json:"old_password"`
+		New string `json:"new_password"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&c)
+	if err != nil {
+		http.Error(w, "400 "+err.Error(), http.StatusBadRequest)
+		log.Error("Decoding password from request body: ", err.Error())
+		return
+	}
+	u, _ := requestUser(r)
+	authMu.Lock()
+	hash := dummyPasswordHash
+	if n := getUserNum(users, u.Username); n >= 0 {
+		hash = users[n].PasswordHash
+	}
+	authMu.Unlock()
+	if !checkPassword(hash, c.Old) {
+		http.Error(w, "403 invalid password", http.StatusForbidden)
+		log.WithField("Username: ", u.Username).Warn("Invalid password.")
+		return
+	}
+	if c.New == "" {
+		http.Error(w, "400 new password is not specified", http.StatusBadRequest)
+		log.Error("New password is not specified.")
+		return
+	}
+	newHash, err := hashPassword(c.New)
+	if err != nil {
+		http.Error(w, "500 "+err.Error(), http.StatusInternalServerError)
+		log.Error("Hashing password: ", err.Error())
+		return
+	}
+	authMu.Lock()
+	defer authMu.Unlock()
+	n := getUserNum(users, u.Username)
+	if n < 0 || users[n].PasswordHash != hash {
+		http.Error(w, "409 password was changed concurrently", http.StatusConflict)
+		log.WithField("Username: ", u.Username).Warn("Password was changed concurrently.")
+		return
+	}
+	users[n].PasswordHash = newHash
+	removeUserSessions(u.Username)
+	_, err = fmt.Fprint(w, "password changed")
+	end := time.Now()
+	execTime := end.Sub(start)
+	if err != nil {
+		log.WithFields(log.Fields{"execution time": execTime}).Fatal("passwordHandler ended: " + err.Error())
+	}
+	log.WithFields(log.Fields{"execution time": execTime}).Info("passwordHandler ended")
+}
+
+func requireAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if u, ok := requestUser(r); !ok || !u.Admin {
+		http.Error(w, "403 forbidden", http.StatusForbidden)
+		log.WithField("Username: ", u.Username).Warn("Admin rights required.")
+		return false
+	}
+	return true
+}
+
+func usersHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	log.WithFields(log.Fields{"method": r.Method, "url": r.URL.String()}).Info("usersHandler started")
+	if !requireAdmin(w, r) {
+		return
+	}
+	authMu.Lock()
+	us := []user{}
+	for i := 0; i < len(users); i++ {
+		u := users[i]
+		u.PasswordHash = ""
+		us = append(us, u)
+	}
+	authMu.Unlock()
+	err := json.NewEncoder(w).Encode(us)
+	end := time.Now()
+	execTime := end.Sub(start)
+	if err != nil {
+		log.WithFields(log.Fields{"execution time": execTime}).Fatal("usersHandler ended: " + err.Error())
+	}
+	log.WithFields(log.Fields{"execution time": execTime}).Info("usersHandler ended")
+}
+
+func newUserHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	log.WithFields(log.Fields{"method": r.Method, "url": r.URL.String()}).Info("newUserHandler started")
+	if !requireAdmin(w, r) {
+		return
+	}
+	var c credentials
+	err := json.NewDecoder(r.Body).Decode(&c)
+	if err != nil {
+		http.Error(w, "400 "+err.Error(), http.StatusBadRequest)
+		log.Error("Decoding user from request body: ", err.Error())
+		return
+	}
+	hash, err := hashPassword(c.Password)
+	if err != nil {
+		http.Error(w, "500 "+err.Error(), http.StatusInternalServerError)
+		log.Error("Hashing password: ", err.Error())
+		return
+	}
+	authMu.Lock()
+	defer authMu.Unlock()
+	users, err = addUser(users, c, hash)
+	if err != nil {
+		http.Error(w, "400 "+err.Error(), http.StatusBadRequest)
+		log.WithField("Username: ", c.Username).Warn("Creating user: ", err.Error())
+		return
+	}
+	u := users[len(users)-1]
+	u.PasswordHash = ""
+	err = json.NewEncoder(w).Encode(u)
+	end := time.Now()
+	execTime := end.Sub(start)
+	if err != nil {
+		log.WithFields(log.Fields{"execution time": execTime}).Fatal("newUserHandler ended: " + err.Error())
+	}
+	log.WithFields(log.Fields{"execution time": execTime}).Info("newUserHandler ended")
+}
+
+func userDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	log.WithFields(log.Fields{"method": r.Method, "url": r.URL.String()}).Info("userDeleteHandler started")
+	if !requireAdmin(w, r) {
+		return
+	}
+	vars := mux.Vars(r)
+	authMu.Lock()
+	defer authMu.Unlock()
+	n := getUserNum(users, vars["username"])
+	if n < 0 {
+		http.NotFound(w, r)
+		return
+	}
+	if u, _ := requestUser(r); u.Username == vars["username"] {
+		http.Error(w, "400 can not delete yourself", http.StatusBadRequest)
+		log.WithField("Username: ", u.Username).Warn("User tried to delete itself.")
+		return
+	}
+	users = append(users[:n], users[n+1:]...)
+	removeUserSessions(vars["username"])
+	removeUserTokens(vars["username"])
+	forEachWorkspaceTracked(r, func() {
+		removeWorkspaceUser(vars["username"], requestActor(r))
+	})
+	_, err := fmt.Fprint(w, "user deleted")
+	end := time.Now()
+	execTime := end.Sub(start)
+	if err != nil {
+		log.WithFields(log.Fields{"execution time": execTime}).Fatal("userDeleteHandler ended: " + err.Error())
+	}
+	log.WithFields(log.Fields{"execution time": execTime}).Info("userDeleteHandler ended")
+}
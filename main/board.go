@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type boardColumn struct {
+	State    string     `json:"state"`
+	WIPLimit int        `json:"wip_limit"`
+	Tasks    []taskView `json:"tasks"`
+}
+
+type boardMove struct {
+	TaskID   string `json:"task_id"`
+	State    string `json:"state"`
+	Position int    `json:"position"`
+}
+
+func getWIPLimit(groupID int, state string) int {
+	key := fmt.Sprintf("Board.groups.%d.%s", groupID, state)
+	if config.IsSet(key) {
+		return config.GetInt(key)
+	}
+	return config.GetInt("Board.wip_limits." + state)
+}
+
+type wipLimitError struct {
+	State string
+	Limit int
+}
+
+func (e wipLimitError) Error() string {
+	return fmt.Sprintf("column %s reached its WIP limit of %d", e.State, e.Limit)
+}
+
+func checkWIPLimit(ts []task, groupID int, state string) error {
+	lim := getWIPLimit(groupID, state)
+	if lim > 0 && len(getBoardColumn(ts, groupID, state)) >= lim {
+		return wipLimitError{state, lim}
+	}
+	return nil
+}
+
+func checkGroupWIPLimits(ts []task, groupID int, incoming []task) error {
+	states := workflowStates()
+	for i := 0; i < len(states); i++ {
+		var count int
+		for g := 0; g < len(incoming); g++ {
+			if incoming[g].State == states[i] && !incoming[g].Archived {
+				count++
+			}
+		}
+		lim := getWIPLimit(groupID, states[i])
+		if count > 0 && lim > 0 && len(getBoardColumn(ts, groupID, states[i]))+count > lim {
+			return wipLimitError{states[i], lim}
+		}
+	}
+	return nil
+}
+
+func getStateErrorCode(err error) int {
+	var wip wipLimitError
+	if errors.As(err, &wip) {
+		return http.StatusConflict
+	}
+	return http.StatusBadRequest
+}
+
+func sortTasksByPosition(ts []task) []task {
+	for i := 1; i < len(ts); i++ {
+		if ts[i].Position < ts[i-1].Position {
+			t := ts[i]
+			g := i
+			for g > 0 && t.Position < ts[g-1].Position {
+				ts[g] = ts[g-1]
+				g--
+			}
+			ts[g] = t
+		}
+	}
+	return ts
+}
+
+func getBoardColumn(ts []task, groupID int, state string) []task {
+	return sortTasksByPosition(filterTasksByState(getUnarchivedTasks(getTasksByGroupID(ts, groupID)), state))
+}
+
+func getBoard(ts []task, groupID int) []boardColumn {
+	var board []boardColumn
+	states := workflowStates()
+	for i := 0; i < len(states); i++ {
+		board = append(board, boardColumn{
+			State:    states[i],
+			WIPLimit: getWIPLimit(groupID, states[i]),
+			Tasks:    decorateTasks(ts, getBoardColumn(ts, groupID, states[i])),
+		})
+	}
+	return board
+}
+
+func setColumnPositions(ts []task, column []task) []task {
+	for i := 0; i < len(column); i++ {
+		ts[getTaskNumByID(ts, column[i].TaskID)].Position = i
+	}
+	return ts
+}
+
+func moveOnBoard(ts []task, groupID int, m boardMove, force bool) ([]task, int, error) {
+	if !containsTask(ts, m.TaskID) || ts[getTaskNumByID(ts, m.TaskID)].GroupID != groupID {
+		return ts, http.StatusNotFound, fmt.Errorf("task %s is not on this board", m.TaskID)
+	}
+	if !isWorkflowState(m.State) {
+		return ts, http.StatusBadRequest, fmt.Errorf("unknown column %s", m.State)
+	}
+	old := ts[getTaskNumByID(ts, m.TaskID)]
+	var err error
+	if old.State != m.State {
+		ts, err = transitionTask(ts, m.TaskID, m.State, force)
+		if err != nil {
+			return ts, getStateErrorCode(err), err
+		}
+		ts = setColumnPositions(ts, getBoardColumn(ts, groupID, old.State))
+	}
+	var column []task
+	moved := ts[getTaskNumByID(ts, m.TaskID)]
+	others := getBoardColumn(ts, groupID, m.State)
+	for i := 0; i < len(others); i++ {
+		if others[i].TaskID != m.TaskID {
+			column = append(column, others[i])
+		}
+	}
+	pos := m.Position
+	if pos < 0 || pos > len(column) {
+		pos = len(column)
+	}
+	column = append(column[:pos], append([]task{moved}, column[pos:]...)...)
+	return setColumnPositions(ts, column), http.StatusOK, nil
+}
+
+func boardHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	log.WithFields(log.Fields{"method": r.Method, "url": r.URL.String(), "body": r.Body}).Info("boardHandler started")
+	vars := mux.Vars(r)
+	ID, err := strconv.Atoi(vars["id"])
+	if err != nil || !containsGroup(taskGroups, ID) {
+		http.NotFound(w, r)
+		return
+	}
+	err = json.NewEncoder(w).Encode(getBoard(tasks, ID))
+	end := time.Now()
+	execTime := end.Sub(start)
+	if err != nil {
+		log.WithFields(log.Fields{"execution time": execTime}).Fatal("boardHandler ended: " + err.Error())
+	}
+	log.WithFields(log.Fields{"execution time": execTime}).Info("boardHandler ended")
+}
+
+func boardMoveHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	log.WithFields(log.Fields{"method": r.Method, "url": r.URL.String(), "body": r.Body}).Info("boardMoveHandler started")
+	vars := mux.Vars(r)
+	ID, err := strconv.Atoi(vars["id"])
+	if err != nil || !containsGroup(taskGroups, ID) {
+		http.NotFound(w, r)
+		return
+	}
+	var m boardMove
+	err = json.NewDecoder(r.Body).Decode(&m)
+	if err != nil {
+		http.Error(w, "400 "+err.Error(), http.StatusBadRequest)
+		log.Error("Decoding board move from request body: ", err.Error())
+		return
+	}
+	var code int
+	mark := len(activity)
+	tasks, code, err = moveOnBoard(tasks, ID, m, r.URL.Query().Get("force") == "true")
+	if err != nil {
+		http.Error(w, strconv.Itoa(code)+" "+err.Error(), code)
+		log.WithField("Group ID: ", ID).Warn("Board move: ", err.Error())
+		return
+	}
+	setActivityActor(mark, requestActor(r))
+	err = json.NewEncoder(w).Encode(getBoard(tasks, ID))
+	end := time.Now()
+	execTime := end.Sub(start)
+	if err != nil {
+		log.WithFields(log.Fields{"execution time": execTime}).Fatal("boardMoveHandler ended: " + err.Error())
+	}
+	log.WithFields(log.Fields{"execution time": execTime}).Info("boardMoveHandler ended")
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type bulkOperation struct {
+	Op       string          `json:"op"`
+	ID       string          `json:"id,omitempty"`
+	GroupID  int             `json:"group_id,omitempty"`
+	ParentID int             `json:"parent_id,omitempty"`
+	Cascade  bool            `json:"cascade,omitempty"`
+	Force    bool            `json:"force,omitempty"`
+	Data     json.RawMessage `json:"data,omitempty"`
+}
+
+type bulkResult struct {
+	Index  int             `json:"index"`
+	Op     string          `json:"op"`
+	Status int             `json:"status"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  string          `json:"error,omitempty"`
+}
+
+type bulkResponse struct {
+	Atomic    bool         `json:"atomic"`
+	Committed bool         `json:"committed"`
+	Results   []bulkResult `json:"results"`
+}
+
+type storeState struct {
+	Groups      []group
+	Tasks       []task
+	Trash       trashBin
+	Activity    []activityEvent
+	Comments    []comment
+	Attachments []attachment
+	Worklog     []worklogEntry
+	Revisions   []revision
+	Grants      []groupGrant
+	TaskRenames map[string]string
+}
+
+func saveStoreState() []byte {
+	state, _ := json.Marshal(storeState{taskGroups, tasks, trash, activity, comments, attachments, worklog, revisions, grants, taskRenames})
+	return state
+}
+
+func restoreStoreState(saved []byte) {
+	var state storeState
+	_ = json.Unmarshal(saved, &state)
+	taskGroups, tasks, trash, activity = state.Groups, state.Tasks, state.Trash, state.Activity
+	comments, attachments, worklog, revisions = state.Comments, state.Attachments, state.Worklog, state.Revisions
+	grants, taskRenames = state.Grants, state.TaskRenames
+	if taskRenames == nil {
+		taskRenames = map[string]string{}
+	}
+}
+
+func checkBulkAccess(r *http.Request, id int, role string) error {
+	u, ok := requestUser(r)
+	if !ok {
+		return nil
+	}
+	return checkGroupAccess(u, id, role)
+}
+
+func runTaskBulkOperation(r *http.Request, op bulkOperation) (interface{}, error) {
+	if !containsString([]string{"create", "update", "complete", "reopen", "move", "delete"}, op.Op) {
+		return nil, errors.New("unknown operation")
+	}
+	var t task
+	if op.Op == "create" || op.Op == "update" {
+		err := json.Unmarshal(op.Data, &t)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if op.Op == "create" {
+		t, err := createTask(r, t)
+		if err != nil {
+			return nil, err
+		}
+		return decorateTask(tasks, t), nil
+	}
+	if !containsTask(tasks, op.ID) {
+		return nil, statusError{http.StatusNotFound, "task with this ID does not exist"}
+	}
+	err := checkBulkAccess(r, getTaskGroupID(op.ID), "editor")
+	if err != nil {
+		return nil, err
+	}
+	mark := len(activity)
+	switch op.Op {
+	case "update":
+		t, err = updateTask(r, op.ID, t)
+	case "move":
+		t, err = moveTaskToGroup(r, op.ID, op.GroupID)
+	case "delete":
+		tasks, err = deleteTask(tasks, op.ID, op.Cascade)
+		if err != nil {
+			return nil, err
+		}
+		setActivityActor(mark, requestActor(r))
+		return "task deleted", nil
+	default:
+		tasks, err = changeTaskType(tasks, op.ID, op.Op == "complete", op.Op == "complete" && op.Force)
+		if err == nil {
+			setActivityActor(mark, requestActor(r))
+			t = tasks[getTaskNumByID(tasks, op.ID)]
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return decorateTask(tasks, t), nil
+}
+
+func runGroupBulkOperation(r *http.Request, op bulkOperation) (interface{}, error) {
+	if !containsString([]string{"create", "update", "move", "delete"}, op.Op) {
+		return nil, errors.New("unknown operation")
+	}
+	var gr group
+	if op.Op == "create" || op.Op == "update" {
+		err := json.Unmarshal(op.Data, &gr)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if op.Op == "create" {
+		return createGroup(r, gr)
+	}
+	ID, err := strconv.Atoi(op.ID)
+	if err != nil || !containsGroup(taskGroups, ID) {
+		return nil, statusError{http.StatusNotFound, "group with this ID does not exist"}
+	}
+	role := "editor"
+	if op.Op == "delete" {
+		role = "owner"
+	}
+	err = checkBulkAccess(r, ID, role)
+	if err != nil {
+		return nil, err
+	}
+	switch op.Op {
+	case "update":
+		return updateGroup(r, ID, gr)
+	case "move":
+		gr = getGroup(taskGroups, ID)
+		gr.ParentID = op.ParentID
+		return updateGroup(r, ID, gr)
+	}
+	taskGroups, err = removeGroup(taskGroups, ID)
+	if err != nil {
+		return nil, err
+	}
+	return "group deleted", nil
+}
+
+func runBulkOperation(r *http.Request, i int, op bulkOperation, run func(*http.Request, bulkOperation) (interface{}, error)) bulkResult {
+	res := bulkResult{Index: i, Op: op.Op, Status: http.StatusOK}
+	result, err := run(r, op)
+	if err != nil {
+		res.Status = getErrorCode(err)
+		res.Error = err.Error()
+		return res
+	}
+	res.Result, _ = json.Marshal(result)
+	return res
+}
+
+func runBulk(w http.ResponseWriter, r *http.Request, run func(*http.Request, bulkOperation) (interface{}, error)) {
+	var ops []bulkOperation
+	err := json.NewDecoder(r.Body).Decode(&ops)
+	if err != nil {
+		http.Error(w, "400 "+err.Error(), http.StatusBadRequest)
+		log.Error("Decoding bulk operations from request body: ", err.Error())
+		return
+	}
+	if limit := config.GetInt("Bulk.max_operations"); limit > 0 && len(ops) > limit {
+		http.Error(w, "400 too many operations", http.StatusBadRequest)
+		log.Error("Too many bulk operations: ", len(ops))
+		return
+	}
+	resp := bulkResponse{Atomic: r.URL.Query().Get("atomic") == "true", Committed: true, Results: []bulkResult{}}
+	var saved []byte
+	if resp.Atomic {
+		saved = saveStoreState()
+	}
+	for i := 0; i < len(ops); i++ {
+		if resp.Atomic && !resp.Committed {
+			resp.Results = append(resp.Results, bulkResult{Index: i, Op: ops[i].Op, Status: http.StatusFailedDependency, Error: "skipped"})
+			continue
+		}
+		res := runBulkOperation(r, i, ops[i], run)
+		if res.Status != http.StatusOK && resp.Atomic {
+			resp.Committed = false
+		}
+		resp.Results = append(resp.Results, res)
+	}
+	if !resp.Committed {
+		restoreStoreState(saved)
+		log.Warn("Bulk operation failed. All changes are rolled back.")
+		w.WriteHeader(http.StatusBadRequest)
+	}
+	err = json.NewEncoder(w).Encode(resp)
+	if err != nil {
+		log.Fatal("Encoding bulk response: " + err.Error())
+	}
+}
+
+func tasksBulkHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	log.WithFields(log.Fields{"method": r.Method, "url": r.URL.String(), "body": r.Body}).Info("tasksBulkHandler started")
+	runBulk(w, r, runTaskBulkOperation)
+	end := time.Now()
+	execTime := end.Sub(start)
+	log.WithFields(log.Fields{"execution time": execTime}).Info("tasksBulkHandler ended")
+}
+
+func groupsBulkHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	log.WithFields(log.Fields{"method": r.Method, "url": r.URL.String(), "body": r.Body}).Info("groupsBulkHandler started")
+	runBulk(w, r, runGroupBulkOperation)
+	end := time.Now()
+	execTime := end.Sub(start)
+	log.WithFields(log.Fields{"execution time": execTime}).Info("groupsBulkHandler ended")
+}
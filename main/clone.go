@@ -0,0 +1,279 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type cloneOptions struct {
+	Name            string `json:"group_name,omitempty"`
+	ParentID        *int   `json:"parent_id,omitempty"`
+	GroupID         int    `json:"group_id,omitempty"`
+	IncludeTasks    bool   `json:"include_tasks"`
+	IncludeSubtasks bool   `json:"include_subtasks"`
+	ResetCompletion bool   `json:"reset_completion"`
+	ShiftDays       int    `json:"shift_days"`
+}
+
+type groupTree struct {
+	Groups []group    `json:"groups"`
+	Tasks  []taskView `json:"tasks"`
+}
+
+func getUniqueTaskID(ts []task, seed string) string {
+	idLim := config.GetInt("Tasks.tasks_length")
+	salt := ""
+	for i := 1; ; i++ {
+		hash := sha1.New()
+		hash.Write([]byte(seed + salt))
+		id := hex.EncodeToString(hash.Sum(nil))[:idLim]
+		if !containsTask(ts, id) && getTrashTaskNum(id) < 0 {
+			return id
+		}
+		salt = "#" + strconv.Itoa(i)
+	}
+}
+
+func shiftDate(date string, days int) string {
+	d, err := time.Parse(time.RFC3339Nano, date)
+	if err != nil || days == 0 {
+		return date
+	}
+	return d.AddDate(0, 0, days).Format(time.RFC3339Nano)
+}
+
+func cloneTask(ts []task, id string, groupID int, parentID string, opts cloneOptions, ids map[string]string) []task {
+	t := ts[getTaskNumByID(ts, id)]
+	t.CreatedDate = time.Now().Format(time.RFC3339Nano)
+	t.TaskID = getUniqueTaskID(ts, id+"@"+t.CreatedDate)
+	t.GroupID = groupID
+	t.ParentTaskID = parentID
+	t.Archived = false
+	t.ArchivedDate = ""
+	t.DueDate = shiftDate(t.DueDate, opts.ShiftDays)
+	t.Checklist = append([]checklistItem(nil), t.Checklist...)
+	t.BlockedBy = append([]string(nil), t.BlockedBy...)
+	t.AssigneeIDs = append([]string(nil), t.AssigneeIDs...)
+	stateDates := map[string]string{}
+	if opts.ResetCompletion {
+		t.Completed = false
+		t.CompletedDate = ""
+		t.State = initialState()
+		stateDates[t.State] = t.CreatedDate
+		for i := 0; i < len(t.Checklist); i++ {
+			t.Checklist[i].Done = false
+		}
+	} else {
+		t.CompletedDate = shiftDate(t.CompletedDate, opts.ShiftDays)
+		for state, date := range t.StateDates {
+			stateDates[state] = shiftDate(date, opts.ShiftDays)
+		}
+	}
+	t.StateDates = stateDates
+	ids[id] = t.TaskID
+	ts = append(ts, t)
+	logActivity(t.TaskID, "created", map[string]string{"cloned_from": id})
+	if opts.IncludeSubtasks {
+		subtasks := getSubtasks(ts, id)
+		for i := 0; i < len(subtasks); i++ {
+			if _, ok := ids[subtasks[i].TaskID]; !ok {
+				ts = cloneTask(ts, subtasks[i].TaskID, groupID, t.TaskID, opts, ids)
+			}
+		}
+	}
+	return ts
+}
+
+func remapClonedBlockers(ts []task, ids map[string]string, keepExternal bool) []task {
+	for _, newID := range ids {
+		n := getTaskNumByID(ts, newID)
+		var blockers []string
+		for i := 0; i < len(ts[n].BlockedBy); i++ {
+			if id, ok := ids[ts[n].BlockedBy[i]]; ok {
+				blockers = append(blockers, id)
+			} else if keepExternal {
+				blockers = append(blockers, ts[n].BlockedBy[i])
+			}
+		}
+		ts[n].BlockedBy = blockers
+	}
+	return ts
+}
+
+func containsInt(ids []int, id int) bool {
+	for i := 0; i < len(ids); i++ {
+		if ids[i] == id {
+			return true
+		}
+	}
+	return false
+}
+
+func cloneGroup(grs []group, ts []task, id int, parentID int, opts cloneOptions, ids map[string]string) ([]group, []task, []int) {
+	gr := getGroup(grs, id)
+	gr.GroupID = getNextGroupID(grs)
+	gr.ParentID = parentID
+	grs = append(grs, gr)
+	cloned := []int{gr.GroupID}
+	if opts.IncludeTasks {
+		groupTasks := getTasksByGroupID(ts, id)
+		for i := 0; i < len(groupTasks); i++ {
+			if groupTasks[i].ParentTaskID == "" {
+				ts = cloneTask(ts, groupTasks[i].TaskID, gr.GroupID, "", opts, ids)
+			}
+		}
+	}
+	children := getChildren(grs, id)
+	for i := 0; i < len(children); i++ {
+		var childIDs []int
+		grs, ts, childIDs = cloneGroup(grs, ts, children[i].GroupID, gr.GroupID, opts, ids)
+		cloned = append(cloned, childIDs...)
+	}
+	return grs, ts, cloned
+}
+
+func decodeCloneOptions(r *http.Request) (cloneOptions, error) {
+	var opts cloneOptions
+	err := json.NewDecoder(r.Body).Decode(&opts)
+	if err == io.EOF {
+		err = nil
+	}
+	return opts, err
+}
+
+func groupCloneHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	log.WithFields(log.Fields{"method": r.Method, "url": r.URL.String(), "body": r.Body}).Info("groupCloneHandler started")
+	vars := mux.Vars(r)
+	ID, err := strconv.Atoi(vars["id"])
+	if err != nil || !containsGroup(taskGroups, ID) {
+		http.NotFound(w, r)
+		return
+	}
+	opts, err := decodeCloneOptions(r)
+	if err != nil {
+		http.Error(w, "400 "+err.Error(), http.StatusBadRequest)
+		log.Error("Decoding clone options from request body: ", err.Error())
+		return
+	}
+	parentID := getGroup(taskGroups, ID).ParentID
+	if opts.ParentID != nil {
+		parentID = *opts.ParentID
+	}
+	if parentID != 0 && (!containsGroup(taskGroups, parentID) || containsInt(getGroupSubtree(taskGroups, ID), parentID)) {
+		http.Error(w, "400 parent with this ID does not exist", http.StatusBadRequest)
+		log.WithField("Parent ID: ", parentID).Warn("Parent does not exist.")
+		return
+	}
+	if !requireGroupRole(w, r, parentID, "editor") {
+		return
+	}
+	opts.IncludeSubtasks = true
+	mark := len(activity)
+	ids := map[string]string{}
+	var cloned []int
+	taskGroups, tasks, cloned = cloneGroup(taskGroups, tasks, ID, parentID, opts, ids)
+	grantCreator(r, cloned[0], parentID)
+	tasks = remapClonedBlockers(tasks, ids, false)
+	if opts.Name != "" {
+		taskGroups[getGroupNumByID(taskGroups, cloned[0])].Name = opts.Name
+	}
+	setActivityActor(mark, requestActor(r))
+	res := groupTree{Groups: []group{}, Tasks: []taskView{}}
+	for i := 0; i < len(cloned); i++ {
+		res.Groups = append(res.Groups, getGroup(taskGroups, cloned[i]))
+		res.Tasks = append(res.Tasks, decorateTasks(tasks, getTasksByGroupID(tasks, cloned[i]))...)
+	}
+	err = json.NewEncoder(w).Encode(res)
+	end := time.Now()
+	execTime := end.Sub(start)
+	if err != nil {
+		log.WithFields(log.Fields{"execution time": execTime}).Fatal("groupCloneHandler ended: " + err.Error())
+	}
+	log.WithFields(log.Fields{"execution time": execTime}).Info("groupCloneHandler ended")
+}
+
+func duplicateTask(ts []task, id string, opts cloneOptions) ([]task, string, error) {
+	t := ts[getTaskNumByID(ts, id)]
+	groupID := t.GroupID
+	parentID := t.ParentTaskID
+	if opts.GroupID != 0 && opts.GroupID != groupID {
+		if !containsGroup(taskGroups, opts.GroupID) {
+			return ts, "", errors.New("group with this ID does not exist")
+		}
+		groupID = opts.GroupID
+		parentID = ""
+	}
+	incoming := []task{t}
+	if opts.IncludeSubtasks {
+		incoming = getTaskTree(ts, id)
+	}
+	for i := 0; i < len(incoming); i++ {
+		incoming[i].TaskID = ""
+		incoming[i].GroupID = groupID
+		incoming[i].ParentTaskID = ""
+		incoming[i].Archived = false
+		if opts.ResetCompletion {
+			incoming[i].Completed = false
+			incoming[i].State = initialState()
+		}
+	}
+	err := checkGroupWIPLimits(ts, groupID, incoming)
+	if err != nil {
+		return ts, "", err
+	}
+	incoming[0].ParentTaskID = parentID
+	err = checkParentCompletion(withTasks(ts, incoming), parentID)
+	if err != nil {
+		return ts, "", err
+	}
+	ids := map[string]string{}
+	ts = cloneTask(ts, id, groupID, parentID, opts, ids)
+	ts = remapClonedBlockers(ts, ids, true)
+	ts, err = syncParentCompletion(ts, parentID)
+	return ts, ids[id], err
+}
+
+func taskDuplicateHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	log.WithFields(log.Fields{"method": r.Method, "url": r.URL.String(), "body": r.Body}).Info("taskDuplicateHandler started")
+	vars := mux.Vars(r)
+	if !containsTask(tasks, vars["id"]) {
+		http.NotFound(w, r)
+		return
+	}
+	opts, err := decodeCloneOptions(r)
+	if err != nil {
+		http.Error(w, "400 "+err.Error(), http.StatusBadRequest)
+		log.Error("Decoding clone options from request body: ", err.Error())
+		return
+	}
+	if opts.GroupID != 0 && !requireGroupRole(w, r, opts.GroupID, "editor") {
+		return
+	}
+	mark := len(activity)
+	var id string
+	tasks, id, err = duplicateTask(tasks, vars["id"], opts)
+	if err != nil {
+		code := getStateErrorCode(err)
+		http.Error(w, strconv.Itoa(code)+" "+err.Error(), code)
+		log.WithField("Task ID: ", vars["id"]).Warn("Duplicating task: ", err.Error())
+		return
+	}
+	setActivityActor(mark, requestActor(r))
+	err = json.NewEncoder(w).Encode(decorateTask(tasks, tasks[getTaskNumByID(tasks, id)]))
+	end := time.Now()
+	execTime := end.Sub(start)
+	if err != nil {
+		log.WithFields(log.Fields{"execution time": execTime}).Fatal("taskDuplicateHandler ended: " + err.Error())
+	}
+	log.WithFields(log.Fields{"execution time": execTime}).Info("taskDuplicateHandler ended")
+}
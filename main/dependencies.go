@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type dependencyGraph struct {
+	Nodes []taskView       `json:"nodes"`
+	Edges []dependencyEdge `json:"edges"`
+}
+
+type dependencyEdge struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+func isBlocked(ts []task, t task) bool {
+	for i := 0; i < len(t.BlockedBy); i++ {
+		if containsTask(ts, t.BlockedBy[i]) && !ts[getTaskNumByID(ts, t.BlockedBy[i])].Completed {
+			return true
+		}
+	}
+	return false
+}
+
+func dependsOn(ts []task, id string, blockerID string) bool {
+	if !containsTask(ts, id) {
+		return false
+	}
+	blockers := ts[getTaskNumByID(ts, id)].BlockedBy
+	for i := 0; i < len(blockers); i++ {
+		if blockers[i] == blockerID || dependsOn(ts, blockers[i], blockerID) {
+			return true
+		}
+	}
+	return false
+}
+
+func validateBlockers(ts []task, id string, blockers []string) error {
+	for i := 0; i < len(blockers); i++ {
+		if !containsTask(ts, blockers[i]) {
+			return errors.New("blocking task with this ID does not exist")
+		}
+		if blockers[i] == id || dependsOn(ts, blockers[i], id) {
+			return errors.New("dependency creates a cycle")
+		}
+		for g := 0; g < i; g++ {
+			if blockers[g] == blockers[i] {
+				return errors.New("dependency already exists")
+			}
+		}
+	}
+	return nil
+}
+
+func addDependency(ts []task, id string, blockerID string) ([]task, error) {
+	n := getTaskNumByID(ts, id)
+	blockers := append(append([]string(nil), ts[n].BlockedBy...), blockerID)
+	err := validateBlockers(ts, id, blockers)
+	if err != nil {
+		return ts, err
+	}
+	ts[n].BlockedBy = blockers
+	return ts, nil
+}
+
+func removeDependency(ts []task, id string, blockerID string) ([]task, error) {
+	n := getTaskNumByID(ts, id)
+	for i := 0; i < len(ts[n].BlockedBy); i++ {
+		if ts[n].BlockedBy[i] == blockerID {
+			ts[n].BlockedBy = append(ts[n].BlockedBy[:i:i], ts[n].BlockedBy[i+1:]...)
+			if len(ts[n].BlockedBy) == 0 {
+				ts[n].BlockedBy = nil
+			}
+			return ts, nil
+		}
+	}
+	return ts, errors.New("dependency does not exist")
+}
+
+func removeDependencyReferences(ts []task, id string) []task {
+	for i := 0; i < len(ts); i++ {
+		for containsString(ts[i].BlockedBy, id) {
+			ts, _ = removeDependency(ts, ts[i].TaskID, id)
+		}
+	}
+	return ts
+}
+
+func containsString(list []string, s string) bool {
+	for i := 0; i < len(list); i++ {
+		if list[i] == s {
+			return true
+		}
+	}
+	return false
+}
+
+func getDependencyGraph(ts []task, groupID int) dependencyGraph {
+	graph := dependencyGraph{Nodes: []taskView{}, Edges: []dependencyEdge{}}
+	groupTasks := getTasksByGroupID(ts, groupID)
+	var ids []string
+	addNode := func(id string) {
+		if !containsString(ids, id) && containsTask(ts, id) {
+			ids = append(ids, id)
+			graph.Nodes = append(graph.Nodes, decorateTask(ts, ts[getTaskNumByID(ts, id)]))
+		}
+	}
+	for i := 0; i < len(groupTasks); i++ {
+		addNode(groupTasks[i].TaskID)
+	}
+	for i := 0; i < len(ts); i++ {
+		for g := 0; g < len(ts[i].BlockedBy); g++ {
+			if ts[i].GroupID == groupID || (containsTask(ts, ts[i].BlockedBy[g]) && ts[getTaskNumByID(ts, ts[i].BlockedBy[g])].GroupID == groupID) {
+				addNode(ts[i].TaskID)
+				addNode(ts[i].BlockedBy[g])
+				graph.Edges = append(graph.Edges, dependencyEdge{From: ts[i].BlockedBy[g], To: ts[i].TaskID})
+			}
+		}
+	}
+	return graph
+}
+
+func dependencyGraphToDOT(graph dependencyGraph, name string) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "digraph %s {\n", strconv.Quote(name))
+	for i := 0; i < len(graph.Nodes); i++ {
+		attrs := ""
+		if graph.Nodes[i].Completed {
+			attrs = ", style=filled, fillcolor=palegreen"
+		} else if graph.Nodes[i].Blocked {
+			attrs = ", color=red"
+		}
+		fmt.Fprintf(&b, "\t%s [label=%s%s];\n", strconv.Quote(graph.Nodes[i].TaskID), strconv.Quote(graph.Nodes[i].Task), attrs)
+	}
+	for i := 0; i < len(graph.Edges); i++ {
+		fmt.Fprintf(&b, "\t%s -> %s;\n", strconv.Quote(graph.Edges[i].From), strconv.Quote(graph.Edges[i].To))
+	}
+	b.WriteString("}\n")
+	return b.Bytes()
+}
+
+func dependenciesHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	log.WithFields(log.Fields{"method": r.Method, "url": r.URL.String(), "body": r.Body}).Info("dependenciesHandler started")
+	vars := mux.Vars(r)
+	if !containsTask(tasks, vars["id"]) {
+		http.NotFound(w, r)
+		return
+	}
+	t := tasks[getTaskNumByID(tasks, vars["id"])]
+	blockers := []taskView{}
+	for i := 0; i < len(t.BlockedBy); i++ {
+		if containsTask(tasks, t.BlockedBy[i]) {
+			blockers = append(blockers, decorateTask(tasks, tasks[getTaskNumByID(tasks, t.BlockedBy[i])]))
+		}
+	}
+	blocking := []taskView{}
+	for i := 0; i < len(tasks); i++ {
+		if containsString(tasks[i].BlockedBy, t.TaskID) {
+			blocking = append(blocking, decorateTask(tasks, tasks[i]))
+		}
+	}
+	err := json.NewEncoder(w).Encode(map[string][]taskView{"blocked_by": blockers, "blocking": blocking})
+	end := time.Now()
+	execTime := end.Sub(start)
+	if err != nil {
+		log.WithFields(log.Fields{"execution time": execTime}).Fatal("dependenciesHandler ended: " + err.Error())
+	}
+	log.WithFields(log.Fields{"execution time": execTime}).Info("dependenciesHandler ended")
+}
+
+func newDependencyHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	log.WithFields(log.Fields{"method": r.Method, "url": r.URL.String(), "body": r.Body}).Info("newDependencyHandler started")
+	vars := mux.Vars(r)
+	if !containsTask(tasks, vars["id"]) {
+		http.NotFound(w, r)
+		return
+	}
+	var dep struct {
+		BlockedBy string `json:"blocked_by"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&dep)
+	if err != nil {
+		http.Error(w, "400 "+err.Error(), http.StatusBadRequest)
+		log.Error("Decoding dependency from request body: ", err.Error())
+		return
+	}
+	tasks, err = addDependency(tasks, vars["id"], dep.BlockedBy)
+	if err != nil {
+		http.Error(w, "400 "+err.Error(), http.StatusBadRequest)
+		log.WithField("Task ID: ", vars["id"]).Warn("Dependency: ", err.Error())
+		return
+	}
+	err = json.NewEncoder(w).Encode(decorateTask(tasks, tasks[getTaskNumByID(tasks, vars["id"])]))
+	end := time.Now()
+	execTime := end.Sub(start)
+	if err != nil {
+		log.WithFields(log.Fields{"execution time": execTime}).Fatal("newDependencyHandler ended: " + err.Error())
+	}
+	log.WithFields(log.Fields{"execution time": execTime}).Info("newDependencyHandler ended")
+}
+
+func dependencyDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	log.WithFields(log.Fields{"method": r.Method, "url": r.URL.String(), "body": r.Body}).Info("dependencyDeleteHandler started")
+	vars := mux.Vars(r)
+	if !containsTask(tasks, vars["id"]) {
+		http.NotFound(w, r)
+		return
+	}
+	var err error
+	tasks, err = removeDependency(tasks, vars["id"], vars["blocker"])
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	err = json.NewEncoder(w).Encode(decorateTask(tasks, tasks[getTaskNumByID(tasks, vars["id"])]))
+	end := time.Now()
+	execTime := end.Sub(start)
+	if err != nil {
+		log.WithFields(log.Fields{"execution time": execTime}).Fatal("dependencyDeleteHandler ended: " + err.Error())
+	}
+	log.WithFields(log.Fields{"execution time": execTime}).Info("dependencyDeleteHandler ended")
+}
+
+func dependencyGraphHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	log.WithFields(log.Fields{"method": r.Method, "url": r.URL.String(), "body": r.Body}).Info("dependencyGraphHandler started")
+	vars := mux.Vars(r)
+	ID, err := strconv.Atoi(vars["id"])
+	if err != nil || !containsGroup(taskGroups, ID) {
+		http.NotFound(w, r)
+		return
+	}
+	graph := getDependencyGraph(tasks, ID)
+	switch r.URL.Query().Get("format") {
+	case "", "json":
+		err = json.NewEncoder(w).Encode(graph)
+	case "dot":
+		w.Header().Set("Content-Type", "text/vnd.graphviz; charset=utf-8")
+		_, err = w.Write(dependencyGraphToDOT(graph, getGroup(taskGroups, ID).Name))
+	default:
+		http.Error(w, "400 bad request", http.StatusBadRequest)
+		log.Error("Invalid query.")
+		return
+	}
+	end := time.Now()
+	execTime := end.Sub(start)
+	if err != nil {
+		log.WithFields(log.Fields{"execution time": execTime}).Fatal("dependencyGraphHandler ended: " + err.Error())
+	}
+	log.WithFields(log.Fields{"execution time": execTime}).Info("dependencyGraphHandler ended")
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type streamEvent struct {
+	ID  string `json:"event_id"`
+	seq int
+	changeEvent
+}
+
+type eventSubscriber struct {
+	user      user
+	token     string
+	workspace string
+	all       bool
+	groupIDs  []int
+	events    chan streamEvent
+	done      chan struct{}
+	revoked   bool
+}
+
+var errSubscriptionClosed = errors.New("subscription closed")
+
+var eventMu sync.Mutex
+
+var eventBuffer []streamEvent
+
+var lastEventSeq int
+
+var eventEpoch = strconv.FormatInt(time.Now().UnixMilli(), 10)
+
+var eventSubscribers = map[*eventSubscriber]bool{}
+
+func isStreamRequest(r *http.Request) bool {
+	template := getRouteTemplate(r)
+	return template == "/events" || template == "/ws"
+}
+
+func isStreamEvent(e changeEvent) bool {
+	return e.Event == e.Resource+".created" || e.Event == e.Resource+".updated" || e.Event == e.Resource+".deleted"
+}
+
+func (s *eventSubscriber) matches(e changeEvent, known []group) bool {
+	if e.Workspace != s.workspace {
+		return false
+	}
+	if !s.user.Admin && !hasGroupRole(known, s.user, e.GroupID, "viewer") {
+		return false
+	}
+	if s.all {
+		return true
+	}
+	for i := 0; i < len(s.groupIDs); i++ {
+		if containsInt(getGroupSubtree(known, s.groupIDs[i]), e.GroupID) {
+			return true
+		}
+	}
+	return false
+}
+
+func dropSubscriber(s *eventSubscriber) {
+	if eventSubscribers[s] {
+		delete(eventSubscribers, s)
+		close(s.done)
+	}
+}
+
+func broadcastEvents(events []changeEvent) {
+	eventMu.Lock()
+	defer eventMu.Unlock()
+	known := getKnownGroups()
+	size := baseConfig.GetInt("Events.buffer_size")
+	for i := 0; i < len(events); i++ {
+		if !isStreamEvent(events[i]) {
+			continue
+		}
+		lastEventSeq++
+		se := streamEvent{ID: eventEpoch + "-" + strconv.Itoa(lastEventSeq), seq: lastEventSeq, changeEvent: events[i]}
+		eventBuffer = append(eventBuffer, se)
+		if size > 0 && len(eventBuffer) > size {
+			eventBuffer = eventBuffer[len(eventBuffer)-size:]
+		}
+		for s := range eventSubscribers {
+			if !s.matches(se.changeEvent, known) {
+				continue
+			}
+			select {
+			case s.events <- se:
+			default:
+				log.WithField("Username: ", s.user.Username).Warn("Event subscriber is too slow, dropping it.")
+				dropSubscriber(s)
+			}
+		}
+	}
+}
+
+func subscribeEvents(s *eventSubscriber, lastID string) ([]streamEvent, bool) {
+	eventMu.Lock()
+	defer eventMu.Unlock()
+	eventSubscribers[s] = true
+	return getMissedEvents(s, lastID)
+}
+
+func parseEventID(id string) (int, bool) {
+	seq, ok := strings.CutPrefix(id, eventEpoch+"-")
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.Atoi(seq)
+	return n, err == nil && n >= 0
+}
+
+func getMissedEvents(s *eventSubscriber, lastID string) ([]streamEvent, bool) {
+	if lastID == "" {
+		return nil, false
+	}
+	seq, ok := parseEventID(lastID)
+	if !ok || seq > lastEventSeq || (len(eventBuffer) != 0 && seq < eventBuffer[0].seq-1) {
+		return nil, true
+	}
+	known := getKnownGroups()
+	var missed []streamEvent
+	for i := 0; i < len(eventBuffer); i++ {
+		if eventBuffer[i].seq > seq && s.matches(eventBuffer[i].changeEvent, known) {
+			missed = append(missed, eventBuffer[i])
+		}
+	}
+	return missed, false
+}
+
+func isAuthChange(r *http.Request) bool {
+	switch getRouteTemplate(r) {
+	case "/logout", "/me/password", "/me/tokens/{token:[0-9]+}", "/users/{username}", "/workspaces/{name:[a-z0-9_-]+}/members/{username}":
+		return r.Method != "GET"
+	}
+	return false
+}
+
+func revalidateSubscriber(s *eventSubscriber) bool {
+	u, _, workspace, ok := authenticateBearer(s.token)
+	ok = ok && (workspace == "" || workspace == s.workspace) && canAccessWorkspace(u, s.workspace)
+	eventMu.Lock()
+	defer eventMu.Unlock()
+	if !ok {
+		if eventSubscribers[s] {
+			log.WithField("Username: ", s.user.Username).Info("Event subscriber is no longer authorized, dropping it.")
+			s.revoked = true
+		}
+		dropSubscriber(s)
+		return false
+	}
+	s.user = u
+	return true
+}
+
+func revalidateSubscribers() {
+	eventMu.Lock()
+	subs := make([]*eventSubscriber, 0, len(eventSubscribers))
+	for s := range eventSubscribers {
+		subs = append(subs, s)
+	}
+	eventMu.Unlock()
+	for i := 0; i < len(subs); i++ {
+		revalidateSubscriber(subs[i])
+	}
+}
+
+func unsubscribeEvents(s *eventSubscriber) {
+	eventMu.Lock()
+	dropSubscriber(s)
+	eventMu.Unlock()
+}
+
+func closeEventSubscribers() {
+	eventMu.Lock()
+	for s := range eventSubscribers {
+		dropSubscriber(s)
+	}
+	eventMu.Unlock()
+}
+
+func getLastEventID(r *http.Request) string {
+	id := r.Header.Get("Last-Event-ID")
+	if id == "" {
+		id = r.URL.Query().Get("last_event_id")
+	}
+	return id
+}
+
+func newEventSubscriber(w http.ResponseWriter, r *http.Request, all bool) (*eventSubscriber, []streamEvent, bool, bool) {
+	storeMu.Lock()
+	defer storeMu.Unlock()
+	if !selectWorkspace(w, r) {
+		return nil, nil, false, false
+	}
+	u, _ := requestUser(r)
+	s := &eventSubscriber{user: u, token: getBearerToken(r), workspace: activeWorkspace, all: all, events: make(chan streamEvent, baseConfig.GetInt("Events.client_buffer")), done: make(chan struct{})}
+	if g := r.URL.Query().Get("group_id"); g != "" {
+		id, err := strconv.Atoi(g)
+		if err != nil || !canSubscribeGroup(u, id) {
+			http.NotFound(w, r)
+			return nil, nil, false, false
+		}
+		s.all = false
+		s.groupIDs = []int{id}
+	}
+	missed, reset := subscribeEvents(s, getLastEventID(r))
+	return s, missed, reset, true
+}
+
+func canSubscribeGroup(u user, id int) bool {
+	return containsGroup(taskGroups, id) && (u.Admin || hasGroupRole(getKnownGroups(), u, id, "viewer"))
+}
+
+func writeStreamEvent(w http.ResponseWriter, e streamEvent) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", e.ID, e.Event, data)
+	return err
+}
+
+func eventsHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	log.WithFields(log.Fields{"method": r.Method, "url": r.URL.String()}).Info("eventsHandler started")
+	rc := http.NewResponseController(w)
+	err := rc.SetWriteDeadline(time.Time{})
+	if err != nil {
+		http.Error(w, "500 streaming is not supported", http.StatusInternalServerError)
+		log.Error("Disabling write deadline: ", err.Error())
+		return
+	}
+	s, missed, reset, ok := newEventSubscriber(w, r, true)
+	if !ok {
+		return
+	}
+	defer unsubscribeEvents(s)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	_, err = fmt.Fprint(w, "retry: 3000\n\n")
+	if reset {
+		_, err = fmt.Fprint(w, "event: reset\ndata: {}\n\n")
+	}
+	for i := 0; i < len(missed) && err == nil; i++ {
+		err = writeStreamEvent(w, missed[i])
+	}
+	heartbeat, perr := time.ParseDuration(baseConfig.GetString("Events.heartbeat"))
+	if perr != nil || heartbeat <= 0 {
+		heartbeat = 15 * time.Second
+	}
+	ticker := time.NewTicker(heartbeat)
+	defer ticker.Stop()
+	for err == nil {
+		err = rc.Flush()
+		if err != nil {
+			break
+		}
+		select {
+		case e := <-s.events:
+			err = writeStreamEvent(w, e)
+		case <-ticker.C:
+			if revalidateSubscriber(s) {
+				_, err = fmt.Fprint(w, ": ping\n\n")
+			}
+		case <-s.done:
+			err = errSubscriptionClosed
+		case <-r.Context().Done():
+			err = r.Context().Err()
+		}
+	}
+	end := time.Now()
+	execTime := end.Sub(start)
+	log.WithFields(log.Fields{"execution time": execTime}).Info("eventsHandler ended: " + err.Error())
+}
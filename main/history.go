@@ -0,0 +1,465 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"reflect"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type revision struct {
+	RevisionID  int                    `json:"revision_id"`
+	Resource    string                 `json:"resource"`
+	ResourceID  string                 `json:"resource_id"`
+	Action      string                 `json:"action"`
+	Actor       string                 `json:"actor"`
+	ChangedDate string                 `json:"changed_at"`
+	Changes     map[string]fieldChange `json:"changes,omitempty"`
+	Snapshot    json.RawMessage        `json:"snapshot,omitempty"`
+}
+
+type fieldChange struct {
+	Old interface{} `json:"old"`
+	New interface{} `json:"new"`
+}
+
+type recordKey struct {
+	Resource string
+	ID       string
+}
+
+type recordChange struct {
+	Resource string          `json:"resource"`
+	BeforeID string          `json:"before_id,omitempty"`
+	AfterID  string          `json:"after_id,omitempty"`
+	Before   json.RawMessage `json:"before,omitempty"`
+	After    json.RawMessage `json:"after,omitempty"`
+	Action   string          `json:"action,omitempty"`
+}
+
+type spooledBody struct {
+	io.Reader
+	file *os.File
+}
+
+type bufferedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+	stream func(w http.ResponseWriter)
+}
+
+const spoolMemoryLimit = 1 << 20
+
+var storeMu sync.Mutex
+
+var revisions = readRevisions()
+
+var taskRenames = map[string]string{}
+
+var purgedRecords = map[recordKey]json.RawMessage{}
+
+func readRevisions() []revision {
+	var revs []revision
+	readJSONFile("revisions.json", &revs)
+	return revs
+}
+
+func (b *spooledBody) Close() error {
+	if b.file == nil {
+		return nil
+	}
+	b.file.Close()
+	return os.Remove(b.file.Name())
+}
+
+func spoolBody(w http.ResponseWriter, r *http.Request) (*spooledBody, error) {
+	src := http.MaxBytesReader(w, r.Body, baseConfig.GetInt64("Attachments.max_size")+1<<20)
+	var buf bytes.Buffer
+	_, err := io.CopyN(&buf, src, spoolMemoryLimit+1)
+	if err == io.EOF {
+		return &spooledBody{Reader: &buf}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	file, err := ioutil.TempFile("", "request-")
+	if err != nil {
+		return nil, err
+	}
+	body := &spooledBody{Reader: file, file: file}
+	_, err = io.Copy(file, io.MultiReader(&buf, src))
+	if err == nil {
+		_, err = file.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		body.Close()
+		return nil, err
+	}
+	return body, nil
+}
+
+func (b *bufferedResponse) Header() http.Header {
+	return b.header
+}
+
+func (b *bufferedResponse) WriteHeader(status int) {
+	if b.status == 0 {
+		b.status = status
+	}
+}
+
+func (b *bufferedResponse) Write(p []byte) (int, error) {
+	b.WriteHeader(http.StatusOK)
+	return b.body.Write(p)
+}
+
+func (b *bufferedResponse) flush(w http.ResponseWriter) {
+	for k, v := range b.header {
+		w.Header()[k] = v
+	}
+	if b.stream != nil {
+		b.stream(w)
+		return
+	}
+	w.WriteHeader(b.status)
+	_, err := w.Write(b.body.Bytes())
+	if err != nil {
+		log.Warn("Writing response: ", err.Error())
+	}
+}
+
+func deferResponse(w http.ResponseWriter, fn func(w http.ResponseWriter)) {
+	if b, ok := w.(*bufferedResponse); ok {
+		b.stream = fn
+		return
+	}
+	fn(w)
+}
+
+func storeMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isStreamRequest(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		body, err := spoolBody(w, r)
+		if err != nil {
+			code := http.StatusBadRequest
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				code = http.StatusRequestEntityTooLarge
+			}
+			http.Error(w, strconv.Itoa(code)+" "+err.Error(), code)
+			log.Error("Reading request body: ", err.Error())
+			return
+		}
+		defer body.Close()
+		r.Body = body
+		br := &bufferedResponse{header: http.Header{}}
+		if isPasswordRequest(r) {
+			servePassword(next, br, r)
+		} else {
+			serveStore(next, br, r)
+		}
+		if br.status < http.StatusBadRequest && isAuthChange(r) {
+			revalidateSubscribers()
+		}
+		if br.status == 0 {
+			br.status = http.StatusOK
+		}
+		br.flush(w)
+	})
+}
+
+func isPasswordRequest(r *http.Request) bool {
+	template := getRouteTemplate(r)
+	return template == "/login" || template == "/me/password"
+}
+
+func servePassword(next http.Handler, br *bufferedResponse, r *http.Request) {
+	next.ServeHTTP(br, r)
+	workspace, _ := r.Context().Value(workspaceKey).(string)
+	if workspace == "" {
+		workspace = defaultWorkspace
+	}
+	status := br.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	if status == http.StatusUnauthorized {
+		auditRejected(r, workspace, status)
+		return
+	}
+	appendAudit([]auditEntry{newAuditEntry(r, workspace, status)})
+}
+
+func serveStore(next http.Handler, br *bufferedResponse, r *http.Request) {
+	storeMu.Lock()
+	defer storeMu.Unlock()
+	if !selectWorkspace(br, r) {
+		return
+	}
+	if r.Method == "GET" || r.Method == "HEAD" {
+		next.ServeHTTP(br, r)
+		return
+	}
+	changes := trackChanges(requestActor(r), func() {
+		next.ServeHTTP(br, r)
+	})
+	if len(changes) != 0 && !isUndoRequest(r) {
+		pushUndo(undoScope(r), changes)
+	}
+	status := br.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	auditRequest(r, status, changes)
+}
+
+func snapshotRecords() map[recordKey]json.RawMessage {
+	records := map[recordKey]json.RawMessage{}
+	for i := 0; i < len(tasks); i++ {
+		records[recordKey{"task", tasks[i].TaskID}], _ = json.Marshal(tasks[i])
+	}
+	for i := 0; i < len(taskGroups); i++ {
+		records[recordKey{"group", strconv.Itoa(taskGroups[i].GroupID)}], _ = json.Marshal(taskGroups[i])
+	}
+	return records
+}
+
+func trackChanges(actor string, fn func()) []recordChange {
+	before := snapshotRecords()
+	beforeIDs := map[recordKey]string{}
+	taskRenames = map[string]string{}
+	purgedRecords = map[recordKey]json.RawMessage{}
+	fn()
+	for oldID, newID := range taskRenames {
+		if snapshot, ok := before[recordKey{"task", oldID}]; ok {
+			delete(before, recordKey{"task", oldID})
+			before[recordKey{"task", newID}] = snapshot
+			beforeIDs[recordKey{"task", newID}] = oldID
+		}
+	}
+	after := snapshotRecords()
+	now := time.Now().Format(time.RFC3339Nano)
+	var changes []recordChange
+	for key, snapshot := range after {
+		old, ok := before[key]
+		beforeID := key.ID
+		if id, renamed := beforeIDs[key]; renamed {
+			beforeID = id
+		}
+		switch {
+		case !ok:
+			newRevision(key, "created", actor, now, nil, snapshot)
+			changes = append(changes, recordChange{Resource: key.Resource, AfterID: key.ID, After: snapshot})
+		case string(old) != string(snapshot):
+			newRevision(key, "updated", actor, now, diffRecords(old, snapshot), snapshot)
+			changes = append(changes, recordChange{Resource: key.Resource, BeforeID: beforeID, AfterID: key.ID, Before: old, After: snapshot})
+		}
+	}
+	for key, old := range before {
+		if _, ok := after[key]; !ok {
+			newRevision(key, "deleted", actor, now, nil, nil)
+			changes = append(changes, recordChange{Resource: key.Resource, BeforeID: key.ID, Before: old})
+		}
+	}
+	for key, old := range purgedRecords {
+		if _, ok := after[key]; !ok {
+			newRevision(key, "purged", actor, now, nil, nil)
+			changes = append(changes, recordChange{Resource: key.Resource, BeforeID: key.ID, Before: old, Action: "purged"})
+		}
+	}
+	publishChanges(actor, changes)
+	return changes
+}
+
+func recordPurge(resource string, id string, record interface{}) {
+	purgedRecords[recordKey{resource, id}], _ = json.Marshal(record)
+}
+
+func trackSystemChanges(fn func()) {
+	mark := len(activity)
+	changes := trackChanges("system", fn)
+	setActivityActor(mark, "system")
+	auditSystem(changes)
+}
+
+func newRevision(key recordKey, action string, actor string, date string, changes map[string]fieldChange, snapshot json.RawMessage) revision {
+	rev := revision{
+		RevisionID:  1,
+		Resource:    key.Resource,
+		ResourceID:  key.ID,
+		Action:      action,
+		Actor:       actor,
+		ChangedDate: date,
+		Changes:     changes,
+		Snapshot:    snapshot,
+	}
+	if len(revisions) != 0 {
+		rev.RevisionID = revisions[len(revisions)-1].RevisionID + 1
+	}
+	revisions = append(revisions, rev)
+	return rev
+}
+
+func diffRecords(old json.RawMessage, new json.RawMessage) map[string]fieldChange {
+	var o, n map[string]interface{}
+	_ = json.Unmarshal(old, &o)
+	_ = json.Unmarshal(new, &n)
+	changes := map[string]fieldChange{}
+	for k, v := range n {
+		if !reflect.DeepEqual(o[k], v) {
+			changes[k] = fieldChange{Old: o[k], New: v}
+		}
+	}
+	for k, v := range o {
+		if _, ok := n[k]; !ok {
+			changes[k] = fieldChange{Old: v, New: nil}
+		}
+	}
+	return changes
+}
+
+func renameRevisionTask(oldID string, newID string) {
+	taskRenames[oldID] = newID
+	for i := 0; i < len(revisions); i++ {
+		if revisions[i].Resource == "task" && revisions[i].ResourceID == oldID {
+			revisions[i].ResourceID = newID
+		}
+	}
+}
+
+func addBaselineRevisions() {
+	known := map[recordKey]bool{}
+	for i := 0; i < len(revisions); i++ {
+		known[recordKey{revisions[i].Resource, revisions[i].ResourceID}] = true
+	}
+	now := time.Now().Format(time.RFC3339Nano)
+	for i := 0; i < len(taskGroups); i++ {
+		key := recordKey{"group", strconv.Itoa(taskGroups[i].GroupID)}
+		if !known[key] {
+			snapshot, _ := json.Marshal(taskGroups[i])
+			newRevision(key, "created", "system", now, nil, snapshot)
+		}
+	}
+	for i := 0; i < len(tasks); i++ {
+		key := recordKey{"task", tasks[i].TaskID}
+		if !known[key] {
+			snapshot, _ := json.Marshal(tasks[i])
+			newRevision(key, "created", "system", tasks[i].CreatedDate, nil, snapshot)
+		}
+	}
+}
+
+func getHistory(resource string, id string) []revision {
+	var revs []revision
+	for i := 0; i < len(revisions); i++ {
+		if revisions[i].Resource == resource && revisions[i].ResourceID == id {
+			revs = append(revs, revisions[i])
+		}
+	}
+	return revs
+}
+
+func getSnapshotsAsOf(resource string, asOf time.Time) []json.RawMessage {
+	latest := map[string]revision{}
+	var order []string
+	for i := 0; i < len(revisions); i++ {
+		rev := revisions[i]
+		changed, err := time.Parse(time.RFC3339Nano, rev.ChangedDate)
+		if rev.Resource != resource || err != nil || changed.After(asOf) {
+			continue
+		}
+		if _, ok := latest[rev.ResourceID]; !ok {
+			order = append(order, rev.ResourceID)
+		}
+		latest[rev.ResourceID] = rev
+	}
+	var snapshots []json.RawMessage
+	for i := 0; i < len(order); i++ {
+		if rev := latest[order[i]]; rev.Action != "deleted" && rev.Action != "purged" {
+			snapshots = append(snapshots, rev.Snapshot)
+		}
+	}
+	return snapshots
+}
+
+func getTasksAsOf(asOf time.Time) []task {
+	var ts []task
+	snapshots := getSnapshotsAsOf("task", asOf)
+	for i := 0; i < len(snapshots); i++ {
+		var t task
+		if json.Unmarshal(snapshots[i], &t) == nil {
+			ts = append(ts, t)
+		}
+	}
+	return ts
+}
+
+func getGroupsAsOf(asOf time.Time) []group {
+	var grs []group
+	snapshots := getSnapshotsAsOf("group", asOf)
+	for i := 0; i < len(snapshots); i++ {
+		var gr group
+		if json.Unmarshal(snapshots[i], &gr) == nil {
+			grs = append(grs, gr)
+		}
+	}
+	return grs
+}
+
+func parseAsOf(r *http.Request) (time.Time, bool, error) {
+	s := r.URL.Query().Get("as_of")
+	if s == "" {
+		return time.Time{}, false, nil
+	}
+	asOf, err := time.Parse(time.RFC3339Nano, s)
+	return asOf, true, err
+}
+
+func taskHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	log.WithFields(log.Fields{"method": r.Method, "url": r.URL.String(), "body": r.Body}).Info("taskHistoryHandler started")
+	vars := mux.Vars(r)
+	revs := getHistory("task", vars["id"])
+	if revs == nil {
+		http.NotFound(w, r)
+		return
+	}
+	err := json.NewEncoder(w).Encode(revs)
+	end := time.Now()
+	execTime := end.Sub(start)
+	if err != nil {
+		log.WithFields(log.Fields{"execution time": execTime}).Fatal("taskHistoryHandler ended: " + err.Error())
+	}
+	log.WithFields(log.Fields{"execution time": execTime}).Info("taskHistoryHandler ended")
+}
+
+func groupHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	log.WithFields(log.Fields{"method": r.Method, "url": r.URL.String(), "body": r.Body}).Info("groupHistoryHandler started")
+	vars := mux.Vars(r)
+	revs := getHistory("group", vars["id"])
+	if revs == nil {
+		http.NotFound(w, r)
+		return
+	}
+	err := json.NewEncoder(w).Encode(revs)
+	end := time.Now()
+	execTime := end.Sub(start)
+	if err != nil {
+		log.WithFields(log.Fields{"execution time": execTime}).Fatal("groupHistoryHandler ended: " + err.Error())
+	}
+	log.WithFields(log.Fields{"execution time": execTime}).Info("groupHistoryHandler ended")
+}
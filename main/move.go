@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type taskMove struct {
+	TaskIDs   []string `json:"task_ids,omitempty"`
+	FromGroup int      `json:"from_group,omitempty"`
+	GroupID   int      `json:"group_id"`
+}
+
+func validateTaskMove(ts []task, moved []string, id string, groupID int) error {
+	if !containsTask(ts, id) {
+		return errors.New("task " + id + " does not exist")
+	}
+	if !containsGroup(taskGroups, groupID) {
+		return errors.New("group with this ID does not exist")
+	}
+	t := ts[getTaskNumByID(ts, id)]
+	if t.ParentTaskID != "" && !containsString(moved, t.ParentTaskID) && ts[getTaskNumByID(ts, t.ParentTaskID)].GroupID != groupID {
+		return errors.New("subtask must be in the group of its parent task")
+	}
+	return nil
+}
+
+func moveTask(ts []task, id string, groupID int) []task {
+	n := getTaskNumByID(ts, id)
+	if ts[n].GroupID == groupID {
+		return ts
+	}
+	logActivity(id, "moved", map[string]string{"from_group": strconv.Itoa(ts[n].GroupID), "to_group": strconv.Itoa(groupID)})
+	ts[n].GroupID = groupID
+	return moveSubtasks(ts, id, groupID)
+}
+
+func getMovedTaskIDs(ts []task, m taskMove) ([]string, error) {
+	ids := m.TaskIDs
+	if m.FromGroup != 0 {
+		if ids != nil {
+			return nil, errors.New("task_ids and from_group can not be used together")
+		}
+		if !containsGroup(taskGroups, m.FromGroup) {
+			return nil, errors.New("source group with this ID does not exist")
+		}
+		groupTasks := getTasksByGroupID(ts, m.FromGroup)
+		for i := 0; i < len(groupTasks); i++ {
+			if groupTasks[i].ParentTaskID == "" {
+				ids = append(ids, groupTasks[i].TaskID)
+			}
+		}
+	}
+	if len(ids) == 0 {
+		return nil, errors.New("no tasks to move")
+	}
+	for i := 0; i < len(ids); i++ {
+		err := validateTaskMove(ts, ids, ids[i], m.GroupID)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return ids, nil
+}
+
+func getIncomingTasks(ts []task, ids []string, groupID int) []task {
+	var incoming []task
+	seen := map[string]bool{}
+	for i := 0; i < len(ids); i++ {
+		tree := getTaskTree(ts, ids[i])
+		for g := 0; g < len(tree); g++ {
+			if !seen[tree[g].TaskID] && tree[g].GroupID != groupID {
+				incoming = append(incoming, tree[g])
+			}
+			seen[tree[g].TaskID] = true
+		}
+	}
+	return incoming
+}
+
+func moveTasks(ts []task, ids []string, groupID int) ([]task, error) {
+	err := checkGroupWIPLimits(ts, groupID, getIncomingTasks(ts, ids, groupID))
+	if err != nil {
+		return ts, err
+	}
+	for i := 0; i < len(ids); i++ {
+		ts = moveTask(ts, ids[i], groupID)
+	}
+	return ts, nil
+}
+
+func moveTaskToGroup(r *http.Request, id string, groupID int) (task, error) {
+	err := validateTaskMove(tasks, nil, id, groupID)
+	if err != nil {
+		return task{}, err
+	}
+	err = checkGroupRole(r, groupID, "editor")
+	if err != nil {
+		return task{}, err
+	}
+	mark := len(activity)
+	tasks, err = moveTasks(tasks, []string{id}, groupID)
+	if err != nil {
+		return task{}, err
+	}
+	setActivityActor(mark, requestActor(r))
+	return tasks[getTaskNumByID(tasks, id)], nil
+}
+
+func taskMoveHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	log.WithFields(log.Fields{"method": r.Method, "url": r.URL.String(), "body": r.Body}).Info("taskMoveHandler started")
+	vars := mux.Vars(r)
+	if !containsTask(tasks, vars["id"]) {
+		http.NotFound(w, r)
+		return
+	}
+	var m taskMove
+	err := json.NewDecoder(r.Body).Decode(&m)
+	if err != nil {
+		http.Error(w, "400 "+err.Error(), http.StatusBadRequest)
+		log.Error("Decoding move from request body: ", err.Error())
+		return
+	}
+	t, err := moveTaskToGroup(r, vars["id"], m.GroupID)
+	if err != nil {
+		code := getErrorCode(err)
+		http.Error(w, strconv.Itoa(code)+" "+err.Error(), code)
+		log.WithField("Task ID: ", vars["id"]).Warn("Moving task: ", err.Error())
+		return
+	}
+	err = json.NewEncoder(w).Encode(decorateTask(tasks, t))
+	end := time.Now()
+	execTime := end.Sub(start)
+	if err != nil {
+		log.WithFields(log.Fields{"execution time": execTime}).Fatal("taskMoveHandler ended: " + err.Error())
+	}
+	log.WithFields(log.Fields{"execution time": execTime}).Info("taskMoveHandler ended")
+}
+
+func tasksMoveHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	log.WithFields(log.Fields{"method": r.Method, "url": r.URL.String(), "body": r.Body}).Info("tasksMoveHandler started")
+	var m taskMove
+	err := json.NewDecoder(r.Body).Decode(&m)
+	if err != nil {
+		http.Error(w, "400 "+err.Error(), http.StatusBadRequest)
+		log.Error("Decoding move from request body: ", err.Error())
+		return
+	}
+	ids, err := getMovedTaskIDs(tasks, m)
+	if err != nil {
+		http.Error(w, "400 "+err.Error(), http.StatusBadRequest)
+		log.Warn("Moving tasks: ", err.Error())
+		return
+	}
+	if !requireGroupRole(w, r, m.GroupID, "editor") {
+		return
+	}
+	for i := 0; i < len(ids); i++ {
+		if !requireGroupRole(w, r, tasks[getTaskNumByID(tasks, ids[i])].GroupID, "editor") {
+			return
+		}
+	}
+	mark := len(activity)
+	tasks, err = moveTasks(tasks, ids, m.GroupID)
+	if err != nil {
+		code := getStateErrorCode(err)
+		http.Error(w, strconv.Itoa(code)+" "+err.Error(), code)
+		log.Warn("Moving tasks: ", err.Error())
+		return
+	}
+	setActivityActor(mark, requestActor(r))
+	var moved []task
+	for i := 0; i < len(ids); i++ {
+		moved = append(moved, tasks[getTaskNumByID(tasks, ids[i])])
+	}
+	err = json.NewEncoder(w).Encode(decorateTasks(tasks, moved))
+	end := time.Now()
+	execTime := end.Sub(start)
+	if err != nil {
+		log.WithFields(log.Fields{"execution time": execTime}).Fatal("tasksMoveHandler ended: " + err.Error())
+	}
+	log.WithFields(log.Fields{"execution time": execTime}).Info("tasksMoveHandler ended")
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+func getSubtasks(ts []task, id string) []task {
+	var children []task
+	for i := 0; i < len(ts); i++ {
+		if ts[i].ParentTaskID == id {
+			children = append(children, ts[i])
+		}
+	}
+	return children
+}
+
+func getTaskTree(ts []task, id string) []task {
+	tree := []task{ts[getTaskNumByID(ts, id)]}
+	children := getSubtasks(ts, id)
+	for i := 0; i < len(children); i++ {
+		tree = append(tree, getTaskTree(ts, children[i].TaskID)...)
+	}
+	return tree
+}
+
+func isSubtaskOf(ts []task, id string, ancestorID string) bool {
+	for id != "" && containsTask(ts, id) {
+		id = ts[getTaskNumByID(ts, id)].ParentTaskID
+		if id == ancestorID {
+			return true
+		}
+	}
+	return false
+}
+
+func getTaskProgress(ts []task, t task) int {
+	if t.Completed {
+		return 100
+	}
+	children := getSubtasks(ts, t.TaskID)
+	if children != nil {
+		sum := 0
+		for i := 0; i < len(children); i++ {
+			sum += getTaskProgress(ts, children[i])
+		}
+		return sum / len(children)
+	}
+	if len(t.Checklist) != 0 {
+		done := 0
+		for i := 0; i < len(t.Checklist); i++ {
+			if t.Checklist[i].Done {
+				done++
+			}
+		}
+		return done * 100 / len(t.Checklist)
+	}
+	return 0
+}
+
+func decorateTask(ts []task, t task) taskView {
+	return taskView{task: t, Progress: getTaskProgress(ts, t), Blocked: isBlocked(ts, t), LoggedTime: getLoggedTime(t.TaskID)}
+}
+
+func decorateTasks(ts []task, list []task) []taskView {
+	views := make([]taskView, 0, len(list))
+	for i := 0; i < len(list); i++ {
+		views = append(views, decorateTask(ts, list[i]))
+	}
+	return views
+}
+
+func getParentCompletionState(ts []task, id string) (string, bool) {
+	if id == "" || !config.GetBool("Tasks.auto_complete_parents") || !containsTask(ts, id) {
+		return "", false
+	}
+	children := getSubtasks(ts, id)
+	if children == nil {
+		return "", false
+	}
+	done := true
+	for i := 0; i < len(children); i++ {
+		if !children[i].Completed {
+			done = false
+			break
+		}
+	}
+	parent := ts[getTaskNumByID(ts, id)]
+	if parent.Completed == done || done && isBlocked(ts, parent) {
+		return "", false
+	}
+	if done {
+		return terminalStates()[0], true
+	}
+	return initialState(), true
+}
+
+func withTasks(ts []task, changed []task) []task {
+	next := make([]task, 0, len(ts)+len(changed))
+	replaced := map[string]bool{}
+	for i := 0; i < len(ts); i++ {
+		t := ts[i]
+		for g := 0; g < len(changed); g++ {
+			if changed[g].TaskID == t.TaskID {
+				t = changed[g]
+				replaced[t.TaskID] = true
+			}
+		}
+		next = append(next, t)
+	}
+	for i := 0; i < len(changed); i++ {
+		if changed[i].TaskID == "" || !replaced[changed[i].TaskID] {
+			next = append(next, changed[i])
+		}
+	}
+	return next
+}
+
+func withoutTasks(ts []task, removed []task) []task {
+	next := make([]task, 0, len(ts))
+	for i := 0; i < len(ts); i++ {
+		if !containsTask(removed, ts[i].TaskID) {
+			next = append(next, ts[i])
+		}
+	}
+	return next
+}
+
+func checkParentCompletion(ts []task, id string) error {
+	state, ok := getParentCompletionState(ts, id)
+	if !ok {
+		return nil
+	}
+	parent := ts[getTaskNumByID(ts, id)]
+	err := checkWIPLimit(ts, parent.GroupID, state)
+	if err != nil {
+		return err
+	}
+	parent.State = state
+	parent.Completed = isTerminalState(state)
+	return checkParentCompletion(withTasks(ts, []task{parent}), parent.ParentTaskID)
+}
+
+func syncParentCompletion(ts []task, id string) ([]task, error) {
+	state, ok := getParentCompletionState(ts, id)
+	if !ok {
+		return ts, nil
+	}
+	return changeTaskState(ts, id, state, false)
+}
+
+func moveSubtasks(ts []task, id string, groupID int) []task {
+	for i := 0; i < len(ts); i++ {
+		if ts[i].ParentTaskID == id {
+			logActivity(ts[i].TaskID, "moved", map[string]string{"from_group": strconv.Itoa(ts[i].GroupID), "to_group": strconv.Itoa(groupID)})
+			ts[i].GroupID = groupID
+			ts = moveSubtasks(ts, ts[i].TaskID, groupID)
+		}
+	}
+	return ts
+}
+
+func renameTaskReferences(ts []task, oldID string, newID string) []task {
+	renameActivityTask(oldID, newID)
+	renameWorklogTask(oldID, newID)
+	renameAttachmentTask(oldID, newID)
+	renameRevisionTask(oldID, newID)
+	for i := 0; i < len(ts); i++ {
+		if ts[i].ParentTaskID == oldID {
+			ts[i].ParentTaskID = newID
+		}
+		for g := 0; g < len(ts[i].BlockedBy); g++ {
+			if ts[i].BlockedBy[g] == oldID {
+				ts[i].BlockedBy[g] = newID
+			}
+		}
+	}
+	return ts
+}
+
+func deleteTask(ts []task, id string, cascade bool) ([]task, error) {
+	if getSubtasks(ts, id) != nil && !cascade {
+		return ts, errors.New("has subtasks")
+	}
+	if !containsTask(ts, id) {
+		return ts, errors.New("not found")
+	}
+	t := ts[getTaskNumByID(ts, id)]
+	err := checkParentCompletion(withoutTasks(ts, getTaskTree(ts, id)), t.ParentTaskID)
+	if err != nil {
+		return ts, err
+	}
+	ts = removeTaskTree(ts, id)
+	return syncParentCompletion(ts, t.ParentTaskID)
+}
+
+func removeTaskTree(ts []task, id string) []task {
+	children := getSubtasks(ts, id)
+	for i := 0; i < len(children); i++ {
+		ts = removeTaskTree(ts, children[i].TaskID)
+	}
+	trashTask(ts[getTaskNumByID(ts, id)])
+	ts = removeTask(ts, getTaskNumByID(ts, id))
+	return removeDependencyReferences(ts, id)
+}
+
+func validateParentTask(ts []task, t *task, id string) error {
+	if t.ParentTaskID == "" {
+		return nil
+	}
+	if !containsTask(ts, t.ParentTaskID) {
+		return errors.New("parent task with this ID does not exist")
+	}
+	if id != "" && (t.ParentTaskID == id || isSubtaskOf(ts, t.ParentTaskID, id)) {
+		return errors.New("task can not be a subtask of itself")
+	}
+	parent := ts[getTaskNumByID(ts, t.ParentTaskID)]
+	if t.GroupID == 0 {
+		t.GroupID = parent.GroupID
+	}
+	if t.GroupID != parent.GroupID {
+		return errors.New("subtask must be in the group of its parent task")
+	}
+	return nil
+}
+
+func taskDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	log.WithFields(log.Fields{"method": r.Method, "url": r.URL.String(), "body": r.Body}).Info("taskDeleteHandler started")
+	vars := mux.Vars(r)
+	if !containsTask(tasks, vars["id"]) {
+		http.NotFound(w, r)
+		return
+	}
+	var err error
+	mark := len(activity)
+	tasks, err = deleteTask(tasks, vars["id"], r.URL.Query().Get("cascade") == "true")
+	if err != nil {
+		code := getStateErrorCode(err)
+		http.Error(w, strconv.Itoa(code)+" "+err.Error(), code)
+		log.WithField("Task ID: ", vars["id"]).Warn("Task ", err.Error())
+		return
+	}
+	setActivityActor(mark, requestActor(r))
+	_, err = w.Write([]byte("task deleted"))
+	end := time.Now()
+	execTime := end.Sub(start)
+	if err != nil {
+		log.WithFields(log.Fields{"execution time": execTime}).Fatal("taskDeleteHandler ended: " + err.Error())
+	}
+	log.WithFields(log.Fields{"execution time": execTime}).Info("taskDeleteHandler ended")
+}
+
+func subtasksHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	log.WithFields(log.Fields{"method": r.Method, "url": r.URL.String(), "body": r.Body}).Info("subtasksHandler started")
+	vars := mux.Vars(r)
+	if !containsTask(tasks, vars["id"]) {
+		http.NotFound(w, r)
+		return
+	}
+	err := json.NewEncoder(w).Encode(decorateTasks(tasks, getSubtasks(tasks, vars["id"])))
+	end := time.Now()
+	execTime := end.Sub(start)
+	if err != nil {
+		log.WithFields(log.Fields{"execution time": execTime}).Fatal("subtasksHandler ended: " + err.Error())
+	}
+	log.WithFields(log.Fields{"execution time": execTime}).Info("subtasksHandler ended")
+}
+
+func checklistItemHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	log.WithFields(log.Fields{"method": r.Method, "url": r.URL.String(), "body": r.Body}).Info("checklistItemHandler started")
+	vars := mux.Vars(r)
+	if !containsTask(tasks, vars["id"]) {
+		http.NotFound(w, r)
+		return
+	}
+	n := getTaskNumByID(tasks, vars["id"])
+	item, err := strconv.Atoi(vars["item"])
+	if err != nil || item >= len(tasks[n].Checklist) {
+		http.NotFound(w, r)
+		return
+	}
+	switch r.URL.Query().Get("done") {
+	case "true":
+		tasks[n].Checklist[item].Done = true
+	case "false":
+		tasks[n].Checklist[item].Done = false
+	default:
+		http.Error(w, "400 bad request", http.StatusBadRequest)
+		log.Error("Invalid query.")
+		return
+	}
+	err = json.NewEncoder(w).Encode(decorateTask(tasks, tasks[n]))
+	end := time.Now()
+	execTime := end.Sub(start)
+	if err != nil {
+		log.WithFields(log.Fields{"execution time": execTime}).Fatal("checklistItemHandler ended: " + err.Error())
+	}
+	log.WithFields(log.Fields{"execution time": execTime}).Info("checklistItemHandler ended")
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type projectTemplate struct {
+	Name        string            `mapstructure:"name" json:"name"`
+	Description string            `mapstructure:"description" json:"description,omitempty"`
+	Variables   map[string]string `mapstructure:"variables" json:"variables,omitempty"`
+	Group       templateGroup     `mapstructure:"group" json:"group"`
+}
+
+type templateGroup struct {
+	Name        string          `mapstructure:"group_name" json:"group_name"`
+	Description string          `mapstructure:"group_description" json:"group_description,omitempty"`
+	Tasks       []templateTask  `mapstructure:"tasks" json:"tasks,omitempty"`
+	Groups      []templateGroup `mapstructure:"groups" json:"groups,omitempty"`
+}
+
+type templateTask struct {
+	Task      string         `mapstructure:"task" json:"task"`
+	Estimate  float64        `mapstructure:"estimate" json:"estimate,omitempty"`
+	DueIn     *int           `mapstructure:"due_in_days" json:"due_in_days,omitempty"`
+	Checklist []string       `mapstructure:"checklist" json:"checklist,omitempty"`
+	Subtasks  []templateTask `mapstructure:"subtasks" json:"subtasks,omitempty"`
+}
+
+type templateInstance struct {
+	ParentID  int               `json:"parent_id"`
+	Variables map[string]string `json:"variables"`
+	StartDate string            `json:"start_date"`
+}
+
+type templateContext struct {
+	Name      string
+	Variables map[string]string
+	Start     time.Time
+	Result    *groupTree
+}
+
+var templateVariable = regexp.MustCompile(`\{\{\s*(\w+)\s*\}\}`)
+
+func getTemplatePath(name string) string {
+	dir := config.GetString("Templates.dir")
+	exts := []string{".yaml", ".yml", ".json"}
+	for i := 0; i < len(exts); i++ {
+		path := filepath.Join(dir, name+exts[i])
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return ""
+}
+
+func readTemplate(path string) (projectTemplate, error) {
+	var tmpl projectTemplate
+	v := viper.New()
+	v.SetConfigFile(path)
+	err := v.ReadInConfig()
+	if err != nil {
+		return tmpl, err
+	}
+	err = v.Unmarshal(&tmpl)
+	if tmpl.Name == "" {
+		tmpl.Name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	return tmpl, err
+}
+
+func getTemplates() []projectTemplate {
+	templates := []projectTemplate{}
+	files, err := os.ReadDir(config.GetString("Templates.dir"))
+	if err != nil {
+		log.Warn("Reading templates directory: ", err.Error())
+		return templates
+	}
+	for i := 0; i < len(files); i++ {
+		name := files[i].Name()
+		ext := filepath.Ext(name)
+		if files[i].IsDir() || (ext != ".yaml" && ext != ".yml" && ext != ".json") {
+			continue
+		}
+		tmpl, err := readTemplate(filepath.Join(config.GetString("Templates.dir"), name))
+		if err != nil {
+			log.Warn("Reading template ", name, ": ", err.Error())
+			continue
+		}
+		tmpl.Name = strings.TrimSuffix(name, ext)
+		templates = append(templates, tmpl)
+	}
+	sort.SliceStable(templates, func(i, j int) bool { return templates[i].Name < templates[j].Name })
+	return templates
+}
+
+func expandTemplateText(text string, vars map[string]string) (string, error) {
+	var missing []string
+	text = templateVariable.ReplaceAllStringFunc(text, func(s string) string {
+		name := strings.ToLower(templateVariable.FindStringSubmatch(s)[1])
+		if vars[name] == "" {
+			missing = append(missing, name)
+			return s
+		}
+		return vars[name]
+	})
+	if missing != nil {
+		return text, errors.New("variable " + strings.Join(missing, ", ") + " is not specified")
+	}
+	return text, nil
+}
+
+func instantiateTemplateTask(tt templateTask, groupID int, parentID string, ctx templateContext) error {
+	text, err := expandTemplateText(tt.Task, ctx.Variables)
+	if err != nil {
+		return err
+	}
+	t := task{Task: text, GroupID: groupID, ParentTaskID: parentID, Estimate: tt.Estimate}
+	for i := 0; i < len(tt.Checklist); i++ {
+		item, err := expandTemplateText(tt.Checklist[i], ctx.Variables)
+		if err != nil {
+			return err
+		}
+		t.Checklist = append(t.Checklist, checklistItem{Text: item})
+	}
+	if tt.DueIn != nil {
+		t.DueDate = ctx.Start.AddDate(0, 0, *tt.DueIn).Format(time.RFC3339)
+	}
+	err = validateNewTask(tasks, &t)
+	if err != nil {
+		return errors.New("task " + strconv.Quote(t.Task) + ": " + err.Error())
+	}
+	t.TaskID = getUniqueTaskID(tasks, t.Task+"@"+time.Now().Format(time.RFC3339Nano))
+	tasks, err = addTask(tasks, t, map[string]string{"template": ctx.Name})
+	if err != nil {
+		return errors.New("task " + strconv.Quote(t.Task) + ": " + err.Error())
+	}
+	for i := 0; i < len(tt.Subtasks); i++ {
+		err = instantiateTemplateTask(tt.Subtasks[i], groupID, t.TaskID, ctx)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func instantiateTemplateGroup(tg templateGroup, parentID int, ctx templateContext) error {
+	name, err := expandTemplateText(tg.Name, ctx.Variables)
+	if err != nil {
+		return err
+	}
+	description, err := expandTemplateText(tg.Description, ctx.Variables)
+	if err != nil {
+		return err
+	}
+	gr := group{Name: name, Description: description, ParentID: parentID}
+	err = validateNewGroup(taskGroups, &gr)
+	if err != nil {
+		return errors.New("group " + strconv.Quote(gr.Name) + ": " + err.Error())
+	}
+	gr.GroupID = getNextGroupID(taskGroups)
+	taskGroups = append(taskGroups, gr)
+	ctx.Result.Groups = append(ctx.Result.Groups, gr)
+	for i := 0; i < len(tg.Tasks); i++ {
+		err = instantiateTemplateTask(tg.Tasks[i], gr.GroupID, "", ctx)
+		if err != nil {
+			return err
+		}
+	}
+	for i := 0; i < len(tg.Groups); i++ {
+		err = instantiateTemplateGroup(tg.Groups[i], gr.GroupID, ctx)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func instantiateTemplate(tmpl projectTemplate, inst templateInstance) (groupTree, error) {
+	res := groupTree{Groups: []group{}, Tasks: []taskView{}}
+	ctx := templateContext{Name: tmpl.Name, Variables: map[string]string{}, Start: time.Now(), Result: &res}
+	for name, value := range tmpl.Variables {
+		ctx.Variables[strings.ToLower(name)] = value
+	}
+	for name, value := range inst.Variables {
+		ctx.Variables[strings.ToLower(name)] = value
+	}
+	if inst.StartDate != "" {
+		start, err := time.Parse(time.RFC3339, inst.StartDate)
+		if err != nil {
+			return res, errors.New("start date must be in RFC3339 format")
+		}
+		ctx.Start = start
+	}
+	saved := saveStoreState()
+	err := instantiateTemplateGroup(tmpl.Group, inst.ParentID, ctx)
+	if err != nil {
+		restoreStoreState(saved)
+		return res, err
+	}
+	for i := 0; i < len(res.Groups); i++ {
+		res.Tasks = append(res.Tasks, decorateTasks(tasks, getTasksByGroupID(tasks, res.Groups[i].GroupID))...)
+	}
+	return res, nil
+}
+
+func templatesHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	log.WithFields(log.Fields{"method": r.Method, "url": r.URL.String(), "body": r.Body}).Info("templatesHandler started")
+	err := json.NewEncoder(w).Encode(getTemplates())
+	end := time.Now()
+	execTime := end.Sub(start)
+	if err != nil {
+		log.WithFields(log.Fields{"execution time": execTime}).Fatal("templatesHandler ended: " + err.Error())
+	}
+	log.WithFields(log.Fields{"execution time": execTime}).Info("templatesHandler ended")
+}
+
+func templateInstantiateHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	log.WithFields(log.Fields{"method": r.Method, "url": r.URL.String(), "body": r.Body}).Info("templateInstantiateHandler started")
+	vars := mux.Vars(r)
+	path := getTemplatePath(vars["name"])
+	if path == "" {
+		http.NotFound(w, r)
+		return
+	}
+	tmpl, err := readTemplate(path)
+	if err != nil {
+		http.Error(w, "500 "+err.Error(), http.StatusInternalServerError)
+		log.Error("Reading template: ", err.Error())
+		return
+	}
+	var inst templateInstance
+	err = json.NewDecoder(r.Body).Decode(&inst)
+	if err != nil && err != io.EOF {
+		http.Error(w, "400 "+err.Error(), http.StatusBadRequest)
+		log.Error("Decoding template instance from request body: ", err.Error())
+		return
+	}
+	parentID := inst.ParentID
+	if parentID == 0 {
+		parentID = config.GetInt("Groups.default_parent")
+	}
+	if !requireGroupRole(w, r, parentID, "editor") {
+		return
+	}
+	mark := len(activity)
+	res, err := instantiateTemplate(tmpl, inst)
+	if err != nil {
+		http.Error(w, "400 "+err.Error(), http.StatusBadRequest)
+		log.WithField("Template: ", vars["name"]).Warn("Instantiating template: ", err.Error())
+		return
+	}
+	grantCreator(r, res.Groups[0].GroupID, parentID)
+	setActivityActor(mark, requestActor(r))
+	err = json.NewEncoder(w).Encode(res)
+	end := time.Now()
+	execTime := end.Sub(start)
+	if err != nil {
+		log.WithFields(log.Fields{"execution time": execTime}).Fatal("templateInstantiateHandler ended: " + err.Error())
+	}
+	log.WithFields(log.Fields{"execution time": execTime}).Info("templateInstantiateHandler ended")
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type apiToken struct {
+	TokenID      int      `json:"token_id"`
+	Username     string   `json:"username"`
+	Name         string   `json:"name"`
+	Prefix       string   `json:"prefix"`
+	Hash         string   `json:"hash,omitempty"`
+	Scopes       []string `json:"scopes"`
+	Workspace    string   `json:"workspace,omitempty"`
+	ExpiresAt    string   `json:"expires_at,omitempty"`
+	CreatedDate  string   `json:"created_at"`
+	LastUsedDate string   `json:"last_used_at,omitempty"`
+	Token        string   `json:"token,omitempty"`
+}
+
+type tokenRequest struct {
+	Name      string   `json:"name"`
+	Scopes    []string `json:"scopes"`
+	Workspace string   `json:"workspace"`
+	ExpiresIn string   `json:"expires_in"`
+}
+
+const tokenPrefix = "tk_"
+
+var tokenScopes = []string{"read:tasks", "write:tasks", "read:groups", "write:groups", "admin"}
+
+var apiTokens = readTokens()
+
+func readTokens() []apiToken {
+	var ts []apiToken
+	readJSONFile("tokens.json", &ts)
+	return ts
+}
+
+func hashToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+func getTokenNumByHash(hash string) int {
+	for i := 0; i < len(apiTokens); i++ {
+		if apiTokens[i].Hash == hash {
+			return i
+		}
+	}
+	return -1
+}
+
+func getTokenNumByID(username string, id int) int {
+	for i := 0; i < len(apiTokens); i++ {
+		if apiTokens[i].TokenID == id && apiTokens[i].Username == username {
+			return i
+		}
+	}
+	return -1
+}
+
+func newAPIToken(u user, req tokenRequest) (apiToken, error) {
+	if req.Name == "" {
+		return apiToken{}, errors.New("name is not specified")
+	}
+	if len(req.Scopes) == 0 {
+		return apiToken{}, errors.New("scopes are not specified")
+	}
+	for i := 0; i < len(req.Scopes); i++ {
+		if !containsString(tokenScopes, req.Scopes[i]) {
+			return apiToken{}, errors.New("unknown scope " + req.Scopes[i])
+		}
+		if req.Scopes[i] == "admin" && !u.Admin {
+			return apiToken{}, errors.New("admin scope requires admin rights")
+		}
+	}
+	if req.Workspace != "" && (getWorkspaceNum(req.Workspace) < 0 || !canAccessWorkspace(u, req.Workspace)) {
+		return apiToken{}, errors.New("workspace " + req.Workspace + " is not available")
+	}
+	t := apiToken{TokenID: 1, Username: u.Username, Name: req.Name, Scopes: req.Scopes, Workspace: req.Workspace, CreatedDate: time.Now().Format(time.RFC3339Nano)}
+	if req.ExpiresIn != "" {
+		d, err := time.ParseDuration(req.ExpiresIn)
+		if err != nil || d <= 0 {
+			return apiToken{}, errors.New("expires_in must be a positive duration")
+		}
+		t.ExpiresAt = time.Now().Add(d).Format(time.RFC3339)
+	}
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return apiToken{}, err
+	}
+	t.Token = tokenPrefix + hex.EncodeToString(secret)
+	t.Prefix = t.Token[:len(tokenPrefix)+6]
+	t.Hash = hashToken(t.Token)
+	for i := 0; i < len(apiTokens); i++ {
+		if apiTokens[i].TokenID >= t.TokenID {
+			t.TokenID = apiTokens[i].TokenID + 1
+		}
+	}
+	stored := t
+	stored.Token = ""
+	apiTokens = append(apiTokens, stored)
+	return t, nil
+}
+
+func authenticateToken(token string) (user, []string, string, bool) {
+	n := getTokenNumByHash(hashToken(token))
+	if n < 0 {
+		return user{}, nil, "", false
+	}
+	t := apiTokens[n]
+	if t.ExpiresAt != "" {
+		expires, err := time.Parse(time.RFC3339, t.ExpiresAt)
+		if err != nil || time.Now().After(expires) {
+			return user{}, nil, "", false
+		}
+	}
+	u := getUserNum(users, t.Username)
+	if u < 0 {
+		return user{}, nil, "", false
+	}
+	apiTokens[n].LastUsedDate = time.Now().Format(time.RFC3339)
+	return users[u], t.Scopes, t.Workspace, true
+}
+
+func removeUserTokens(username string) {
+	var ts []apiToken
+	for i := 0; i < len(apiTokens); i++ {
+		if apiTokens[i].Username != username {
+			ts = append(ts, apiTokens[i])
+		}
+	}
+	apiTokens = ts
+}
+
+func getRequiredScopes(method string, template string) []string {
+	access := "write:"
+	if method == "GET" || method == "HEAD" {
+		access = "read:"
+	}
+	switch {
+	case template == "/login" || template == "/logout" || template == "/me" || (template == "/workspaces" && access == "read:"):
+		return nil
+	case strings.HasPrefix(template, "/me/tokens") && access == "read:":
+		return nil
+	case template == "/me/tasks":
+		return []string{access + "tasks"}
+	case strings.HasPrefix(template, "/users") || strings.HasPrefix(template, "/me/") || strings.HasPrefix(template, "/workspaces") || strings.HasPrefix(template, "/audit") || strings.HasPrefix(template, "/webhooks"):
+		return []string{"admin"}
+	case template == "/undo" || template == "/redo" || template == "/events" || template == "/ws" || template == "/trash" || strings.HasPrefix(template, "/templates"):
+		return []string{access + "tasks", access + "groups"}
+	case strings.HasPrefix(template, "/groups/{id:[0-9]+}/grants"):
+		return []string{access + "groups"}
+	case strings.HasPrefix(template, "/groups/{id:[0-9]+}/") && !strings.HasSuffix(template, "/history") && !strings.HasSuffix(template, "/clone"):
+		return []string{access + "tasks"}
+	case strings.HasPrefix(template, "/groups") || strings.HasPrefix(template, "/trash/groups"):
+		if strings.HasSuffix(template, "/clone") {
+			return []string{access + "tasks", access + "groups"}
+		}
+		return []string{access + "groups"}
+	}
+	return []string{access + "tasks"}
+}
+
+func checkTokenScopes(r *http.Request, req tokenRequest) error {
+	scopes, ok := r.Context().Value(scopesKey).([]string)
+	if !ok {
+		return nil
+	}
+	if !hasScopes(scopes, req.Scopes) {
+		return errors.New("token can not grant scopes it does not have")
+	}
+	if bound, _ := r.Context().Value(workspaceKey).(string); bound != "" && req.Workspace != bound {
+		return errors.New("token is bound to workspace " + bound)
+	}
+	return nil
+}
+
+func hasScopes(scopes []string, required []string) bool {
+	if containsString(scopes, "admin") {
+		return true
+	}
+	for i := 0; i < len(required); i++ {
+		if !containsString(scopes, required[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func checkScopes(r *http.Request, scopes []string) bool {
+	if scopes == nil {
+		return true
+	}
+	template := getRouteTemplate(r)
+	if template == "" {
+		template = r.URL.Path
+	}
+	return hasScopes(scopes, getRequiredScopes(r.Method, template))
+}
+
+func tokensHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	log.WithFields(log.Fields{"method": r.Method, "url": r.URL.String()}).Info("tokensHandler started")
+	u, _ := requestUser(r)
+	authMu.Lock()
+	ts := []apiToken{}
+	for i := 0; i < len(apiTokens); i++ {
+		if apiTokens[i].Username == u.Username {
+			t := apiTokens[i]
+			t.Hash = ""
+			ts = append(ts, t)
+		}
+	}
+	authMu.Unlock()
+	err := json.NewEncoder(w).Encode(ts)
+	end := time.Now()
+	execTime := end.Sub(start)
+	if err != nil {
+		log.WithFields(log.Fields{"execution time": execTime}).Fatal("tokensHandler ended: " + err.Error())
+	}
+	log.WithFields(log.Fields{"execution time": execTime}).Info("tokensHandler ended")
+}
+
+func newTokenHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	log.WithFields(log.Fields{"method": r.Method, "url": r.URL.String()}).Info("newTokenHandler started")
+	var req tokenRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, "400 "+err.Error(), http.StatusBadRequest)
+		log.Error("Decoding token from request body: ", err.Error())
+		return
+	}
+	u, _ := requestUser(r)
+	err = checkTokenScopes(r, req)
+	if err != nil {
+		http.Error(w, "403 "+err.Error(), http.StatusForbidden)
+		log.WithField("Username: ", u.Username).Warn("Creating token: ", err.Error())
+		return
+	}
+	authMu.Lock()
+	t, err := newAPIToken(u, req)
+	authMu.Unlock()
+	if err != nil {
+		http.Error(w, "400 "+err.Error(), http.StatusBadRequest)
+		log.WithField("Username: ", u.Username).Warn("Creating token: ", err.Error())
+		return
+	}
+	t.Hash = ""
+	err = json.NewEncoder(w).Encode(t)
+	end := time.Now()
+	execTime := end.Sub(start)
+	if err != nil {
+		log.WithFields(log.Fields{"execution time": execTime}).Fatal("newTokenHandler ended: " + err.Error())
+	}
+	log.WithFields(log.Fields{"execution time": execTime}).Info("newTokenHandler ended")
+}
+
+func tokenDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	log.WithFields(log.Fields{"method": r.Method, "url": r.URL.String()}).Info("tokenDeleteHandler started")
+	vars := mux.Vars(r)
+	u, _ := requestUser(r)
+	ID, _ := strconv.Atoi(vars["token"])
+	authMu.Lock()
+	n := getTokenNumByID(u.Username, ID)
+	if n >= 0 {
+		apiTokens = append(apiTokens[:n], apiTokens[n+1:]...)
+	}
+	authMu.Unlock()
+	if n < 0 {
+		http.NotFound(w, r)
+		return
+	}
+	_, err := fmt.Fprint(w, "token revoked")
+	end := time.Now()
+	execTime := end.Sub(start)
+	if err != nil {
+		log.WithFields(log.Fields{"execution time": execTime}).Fatal("tokenDeleteHandler ended: " + err.Error())
+	}
+	log.WithFields(log.Fields{"execution time": execTime}).Info("tokenDeleteHandler ended")
+}
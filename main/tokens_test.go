@@ -0,0 +1,80 @@
+package main
+
+import (
+	"github.com/gorilla/mux"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+type scopeCase struct {
+	token  string
+	method string
+	path   string
+	status int
+}
+
+func setTestTokens(t *testing.T, u user, ts []apiToken) {
+	savedUsers, savedTokens, savedAudit := users, apiTokens, auditLog
+	t.Cleanup(func() {
+		users, apiTokens, auditLog = savedUsers, savedTokens, savedAudit
+	})
+	setTestConfig(t, baseConfig, "Audit.file", filepath.Join(t.TempDir(), "audit.log"))
+	users = []user{u}
+	apiTokens = ts
+}
+
+func checkScopeCases(t *testing.T, r *mux.Router, cases []scopeCase) {
+	r.Use(authMiddleware)
+	for i := 0; i < len(cases); i++ {
+		c := cases[i]
+		req := httptest.NewRequest(c.method, c.path, nil)
+		req.Header.Set("Authorization", "Bearer "+c.token)
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		if rec.Code != c.status {
+			t.Errorf("%s %s with %s: got %d, want %d", c.method, c.path, c.token, rec.Code, c.status)
+		}
+	}
+}
+
+func noContentHandler(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func TestGrantRoutesRequireGroupScope(t *testing.T) {
+	setTestTokens(t, user{Username: "alice", Admin: true}, []apiToken{
+		{TokenID: 1, Username: "alice", Hash: hashToken("tk_tasks"), Scopes: []string{"read:tasks", "write:tasks"}},
+		{TokenID: 2, Username: "alice", Hash: hashToken("tk_groups"), Scopes: []string{"read:groups", "write:groups"}},
+	})
+	r := mux.NewRouter()
+	r.HandleFunc("/groups/{id:[0-9]+}/grants", noContentHandler).Methods("GET")
+	r.HandleFunc("/groups/{id:[0-9]+}/grants/{username}", noContentHandler).Methods("PUT", "DELETE")
+	checkScopeCases(t, r, []scopeCase{
+		{"tk_tasks", "PUT", "/groups/12/grants/bob", http.StatusForbidden},
+		{"tk_tasks", "DELETE", "/groups/12/grants/bob", http.StatusForbidden},
+		{"tk_tasks", "GET", "/groups/12/grants", http.StatusForbidden},
+		{"tk_groups", "PUT", "/groups/12/grants/bob", http.StatusNoContent},
+		{"tk_groups", "GET", "/groups/12/grants", http.StatusNoContent},
+	})
+}
+
+func TestAccountRoutesRequireAdminScope(t *testing.T) {
+	setTestTokens(t, user{Username: "alice"}, []apiToken{
+		{TokenID: 1, Username: "alice", Hash: hashToken("tk_read"), Scopes: []string{"read:tasks"}},
+		{TokenID: 2, Username: "alice", Hash: hashToken("tk_admin"), Scopes: []string{"admin"}},
+	})
+	r := mux.NewRouter()
+	r.HandleFunc("/me/password", noContentHandler).Methods("PUT")
+	r.HandleFunc("/me/tokens", noContentHandler).Methods("GET", "POST")
+	r.HandleFunc("/me/tokens/{token:[0-9]+}", noContentHandler).Methods("DELETE")
+	checkScopeCases(t, r, []scopeCase{
+		{"tk_read", "PUT", "/me/password", http.StatusForbidden},
+		{"tk_read", "POST", "/me/tokens", http.StatusForbidden},
+		{"tk_read", "DELETE", "/me/tokens/2", http.StatusForbidden},
+		{"tk_read", "GET", "/me/tokens", http.StatusNoContent},
+		{"tk_admin", "PUT", "/me/password", http.StatusNoContent},
+		{"tk_admin", "POST", "/me/tokens", http.StatusNoContent},
+	})
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type trashBin struct {
+	Groups []group `json:"groups"`
+	Tasks  []task  `json:"tasks"`
+}
+
+var trash = readTrash()
+
+func readTrash() trashBin {
+	var t trashBin
+	readJSONFile("trash.json", &t)
+	return t
+}
+
+func trashGroup(gr group) {
+	gr.DeletedAt = time.Now().Format(time.RFC3339Nano)
+	trash.Groups = append(trash.Groups, gr)
+}
+
+func trashTask(t task) {
+	t.DeletedAt = time.Now().Format(time.RFC3339Nano)
+	renameTaskRecords(t.TaskID, getTrashKey(t))
+	trash.Tasks = append(trash.Tasks, t)
+}
+
+func getTrashKey(t task) string {
+	return t.TaskID + "@" + t.DeletedAt
+}
+
+func renameTaskRecords(oldID string, newID string) {
+	renameActivityTask(oldID, newID)
+	renameWorklogTask(oldID, newID)
+	renameAttachmentTask(oldID, newID)
+}
+
+func removeTrashedTask(n int) task {
+	t := trash.Tasks[n]
+	renameTaskRecords(getTrashKey(t), t.TaskID)
+	trash.Tasks = append(trash.Tasks[:n], trash.Tasks[n+1:]...)
+	t.DeletedAt = ""
+	return t
+}
+
+func getTrashTaskNum(id string) int {
+	for i := len(trash.Tasks) - 1; i >= 0; i-- {
+		if trash.Tasks[i].TaskID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func getTrashGroupNum(id int) int {
+	for i := len(trash.Groups) - 1; i >= 0; i-- {
+		if trash.Groups[i].GroupID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func restoreTask(ts []task, id string) ([]task, error) {
+	n := getTrashTaskNum(id)
+	if n < 0 {
+		return ts, errors.New("not found")
+	}
+	t := trash.Tasks[n]
+	if containsTask(ts, t.TaskID) {
+		return ts, errors.New("task with this ID already exists")
+	}
+	if !containsGroup(taskGroups, t.GroupID) {
+		return ts, errors.New("group with this ID does not exist")
+	}
+	if t.ParentTaskID != "" && !containsTask(ts, t.ParentTaskID) {
+		return ts, errors.New("parent task with this ID does not exist")
+	}
+	err := checkParentCompletion(withTasks(ts, getTrashedTaskTree(t)), t.ParentTaskID)
+	if err != nil {
+		return ts, err
+	}
+	ts = restoreTaskTree(ts, n)
+	return syncParentCompletion(ts, t.ParentTaskID)
+}
+
+func getTrashedTaskTree(t task) []task {
+	tree := []task{t}
+	for i := 0; i < len(trash.Tasks); i++ {
+		if trash.Tasks[i].ParentTaskID == t.TaskID {
+			tree = append(tree, getTrashedTaskTree(trash.Tasks[i])...)
+		}
+	}
+	return tree
+}
+
+func restoreTaskTree(ts []task, n int) []task {
+	t := removeTrashedTask(n)
+	ts = append(ts, t)
+	var children []string
+	for i := 0; i < len(trash.Tasks); i++ {
+		if trash.Tasks[i].ParentTaskID == t.TaskID {
+			children = append(children, trash.Tasks[i].TaskID)
+		}
+	}
+	for i := 0; i < len(children); i++ {
+		if m := getTrashTaskNum(children[i]); m >= 0 && !containsTask(ts, children[i]) {
+			ts = restoreTaskTree(ts, m)
+		}
+	}
+	return ts
+}
+
+func restoreGroup(grs []group, id int) ([]group, error) {
+	n := getTrashGroupNum(id)
+	if n < 0 {
+		return grs, errors.New("not found")
+	}
+	gr := trash.Groups[n]
+	if containsGroup(grs, gr.GroupID) {
+		return grs, errors.New("group with this ID already exists")
+	}
+	if gr.ParentID != 0 && !containsGroup(grs, gr.ParentID) {
+		return grs, errors.New("parent with this ID does not exist")
+	}
+	gr.DeletedAt = ""
+	trash.Groups = append(trash.Groups[:n], trash.Groups[n+1:]...)
+	return append(grs, gr), nil
+}
+
+func purgeTrash(now time.Time) {
+	retention, err := time.ParseDuration(config.GetString("Trash.retention"))
+	if err != nil || retention <= 0 {
+		return
+	}
+	var ts []task
+	for i := 0; i < len(trash.Tasks); i++ {
+		deleted, err := time.Parse(time.RFC3339Nano, trash.Tasks[i].DeletedAt)
+		if err == nil && now.Sub(deleted) > retention {
+			log.WithField("Task ID: ", trash.Tasks[i].TaskID).Info("Purging task from trash.")
+			recordPurge("task", trash.Tasks[i].TaskID, trash.Tasks[i])
+			removeTaskActivity(getTrashKey(trash.Tasks[i]))
+			removeTaskAttachments(getTrashKey(trash.Tasks[i]))
+			removeTaskWorklog(getTrashKey(trash.Tasks[i]))
+			continue
+		}
+		ts = append(ts, trash.Tasks[i])
+	}
+	trash.Tasks = ts
+	var grs []group
+	for i := 0; i < len(trash.Groups); i++ {
+		deleted, err := time.Parse(time.RFC3339Nano, trash.Groups[i].DeletedAt)
+		if err == nil && now.Sub(deleted) > retention {
+			log.WithField("Group ID: ", trash.Groups[i].GroupID).Info("Purging group from trash.")
+			recordPurge("group", strconv.Itoa(trash.Groups[i].GroupID), trash.Groups[i])
+			continue
+		}
+		grs = append(grs, trash.Groups[i])
+	}
+	trash.Groups = grs
+}
+
+func runTrashPurge() {
+	interval, err := time.ParseDuration(config.GetString("Trash.purge_interval"))
+	if err != nil || interval <= 0 {
+		log.Warn("Trash purge interval is not specified. Trash will not be purged.")
+		return
+	}
+	for now := range time.Tick(interval) {
+		storeMu.Lock()
+		forEachWorkspace(func() {
+			trackSystemChanges(func() {
+				purgeTrash(now)
+			})
+		})
+		storeMu.Unlock()
+	}
+}
+
+func trashHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	log.WithFields(log.Fields{"method": r.Method, "url": r.URL.String(), "body": r.Body}).Info("trashHandler started")
+	t := trashBin{Groups: []group{}, Tasks: []task{}}
+	t.Groups = append(t.Groups, trash.Groups...)
+	t.Tasks = append(t.Tasks, trash.Tasks...)
+	err := json.NewEncoder(w).Encode(t)
+	end := time.Now()
+	execTime := end.Sub(start)
+	if err != nil {
+		log.WithFields(log.Fields{"execution time": execTime}).Fatal("trashHandler ended: " + err.Error())
+	}
+	log.WithFields(log.Fields{"execution time": execTime}).Info("trashHandler ended")
+}
+
+func restoreTaskHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	log.WithFields(log.Fields{"method": r.Method, "url": r.URL.String(), "body": r.Body}).Info("restoreTaskHandler started")
+	vars := mux.Vars(r)
+	if getTrashTaskNum(vars["id"]) < 0 {
+		http.NotFound(w, r)
+		return
+	}
+	var err error
+	tasks, err = restoreTask(tasks, vars["id"])
+	if err != nil {
+		code := getStateErrorCode(err)
+		http.Error(w, strconv.Itoa(code)+" "+err.Error(), code)
+		log.WithField("Task ID: ", vars["id"]).Warn("Restoring task: ", err.Error())
+		return
+	}
+	err = json.NewEncoder(w).Encode(decorateTask(tasks, tasks[getTaskNumByID(tasks, vars["id"])]))
+	end := time.Now()
+	execTime := end.Sub(start)
+	if err != nil {
+		log.WithFields(log.Fields{"execution time": execTime}).Fatal("restoreTaskHandler ended: " + err.Error())
+	}
+	log.WithFields(log.Fields{"execution time": execTime}).Info("restoreTaskHandler ended")
+}
+
+func restoreGroupHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	log.WithFields(log.Fields{"method": r.Method, "url": r.URL.String(), "body": r.Body}).Info("restoreGroupHandler started")
+	vars := mux.Vars(r)
+	ID, err := strconv.Atoi(vars["id"])
+	if err != nil || getTrashGroupNum(ID) < 0 {
+		http.NotFound(w, r)
+		return
+	}
+	taskGroups, err = restoreGroup(taskGroups, ID)
+	if err != nil {
+		http.Error(w, "400 "+err.Error(), http.StatusBadRequest)
+		log.WithField("Group ID: ", ID).Warn("Restoring group: ", err.Error())
+		return
+	}
+	err = json.NewEncoder(w).Encode(getGroup(taskGroups, ID))
+	end := time.Now()
+	execTime := end.Sub(start)
+	if err != nil {
+		log.WithFields(log.Fields{"execution time": execTime}).Fatal("restoreGroupHandler ended: " + err.Error())
+	}
+	log.WithFields(log.Fields{"execution time": execTime}).Info("restoreGroupHandler ended")
+}
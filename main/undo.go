@@ -0,0 +1,246 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type changeSet struct {
+	Date    string         `json:"changed_at"`
+	Changes []recordChange `json:"changes"`
+}
+
+type undoStack struct {
+	Undo []changeSet
+	Redo []changeSet
+}
+
+var undoStacks = map[string]*undoStack{}
+
+func undoScope(r *http.Request) string {
+	scope := activeWorkspace + "/" + requestActor(r)
+	if session := r.Header.Get("X-Session-ID"); session != "" {
+		scope += "/" + session
+	}
+	return scope
+}
+
+func isUndoRequest(r *http.Request) bool {
+	return strings.HasSuffix(r.URL.Path, "/undo") || strings.HasSuffix(r.URL.Path, "/redo")
+}
+
+func getUndoStack(scope string) *undoStack {
+	if undoStacks[scope] == nil {
+		undoStacks[scope] = &undoStack{}
+	}
+	return undoStacks[scope]
+}
+
+func pushUndo(scope string, changes []recordChange) {
+	stack := getUndoStack(scope)
+	stack.Undo = append(stack.Undo, changeSet{Date: time.Now().Format(time.RFC3339Nano), Changes: changes})
+	if depth := config.GetInt("Undo.depth"); depth > 0 && len(stack.Undo) > depth {
+		stack.Undo = stack.Undo[len(stack.Undo)-depth:]
+	}
+	stack.Redo = nil
+}
+
+func currentRecord(resource string, id string) json.RawMessage {
+	switch resource {
+	case "task":
+		if containsTask(tasks, id) {
+			record, _ := json.Marshal(tasks[getTaskNumByID(tasks, id)])
+			return record
+		}
+	case "group":
+		gid, _ := strconv.Atoi(id)
+		if containsGroup(taskGroups, gid) {
+			record, _ := json.Marshal(getGroup(taskGroups, gid))
+			return record
+		}
+	}
+	return nil
+}
+
+func setRecord(resource string, fromID string, toID string, snapshot json.RawMessage) {
+	switch resource {
+	case "task":
+		if fromID != "" && containsTask(tasks, fromID) {
+			n := getTaskNumByID(tasks, fromID)
+			if snapshot == nil {
+				trashTask(tasks[n])
+				tasks = removeTask(tasks, n)
+				return
+			}
+			var t task
+			_ = json.Unmarshal(snapshot, &t)
+			tasks[n] = t
+			if fromID != toID {
+				tasks = renameTaskReferences(tasks, fromID, toID)
+			}
+			return
+		}
+		var t task
+		if json.Unmarshal(snapshot, &t) == nil {
+			if n := getTrashTaskNum(t.TaskID); n >= 0 {
+				removeTrashedTask(n)
+			}
+			tasks = append(tasks, t)
+		}
+	case "group":
+		gid, _ := strconv.Atoi(fromID)
+		if fromID != "" && containsGroup(taskGroups, gid) {
+			n := getGroupNumByID(taskGroups, gid)
+			if snapshot == nil {
+				trashGroup(taskGroups[n])
+				taskGroups = append(taskGroups[:n], taskGroups[n+1:]...)
+				return
+			}
+			var gr group
+			_ = json.Unmarshal(snapshot, &gr)
+			taskGroups[n] = gr
+			return
+		}
+		var gr group
+		if json.Unmarshal(snapshot, &gr) == nil {
+			if n := getTrashGroupNum(gr.GroupID); n >= 0 {
+				trash.Groups = append(trash.Groups[:n], trash.Groups[n+1:]...)
+			}
+			taskGroups = append(taskGroups, gr)
+		}
+	}
+}
+
+func applyChangeSet(cs changeSet, undo bool) error {
+	var conflicts []string
+	for i := 0; i < len(cs.Changes); i++ {
+		c := cs.Changes[i]
+		id, expected := c.AfterID, c.After
+		if !undo {
+			id, expected = c.BeforeID, c.Before
+		}
+		if id == "" {
+			id = c.BeforeID + c.AfterID
+		}
+		if string(currentRecord(c.Resource, id)) != string(expected) {
+			conflicts = append(conflicts, c.Resource+" "+id)
+		}
+	}
+	if conflicts != nil {
+		return errors.New("changed since: " + strings.Join(conflicts, ", "))
+	}
+	err := checkChangeSetReferences(cs, undo)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < len(cs.Changes); i++ {
+		c := cs.Changes[i]
+		if undo {
+			setRecord(c.Resource, c.AfterID, c.BeforeID, c.Before)
+		} else {
+			setRecord(c.Resource, c.BeforeID, c.AfterID, c.After)
+		}
+	}
+	return nil
+}
+
+func checkChangeSetReferences(cs changeSet, undo bool) error {
+	ts := map[string]task{}
+	for i := 0; i < len(tasks); i++ {
+		ts[tasks[i].TaskID] = tasks[i]
+	}
+	grs := map[int]group{}
+	for i := 0; i < len(taskGroups); i++ {
+		grs[taskGroups[i].GroupID] = taskGroups[i]
+	}
+	changedTasks := map[string]bool{}
+	changedGroups := map[int]bool{}
+	for i := 0; i < len(cs.Changes); i++ {
+		c := cs.Changes[i]
+		fromID, snapshot := c.AfterID, c.Before
+		if !undo {
+			fromID, snapshot = c.BeforeID, c.After
+		}
+		switch c.Resource {
+		case "task":
+			changedTasks[fromID] = true
+			delete(ts, fromID)
+			var t task
+			if snapshot != nil && json.Unmarshal(snapshot, &t) == nil {
+				changedTasks[t.TaskID] = true
+				ts[t.TaskID] = t
+			}
+		case "group":
+			gid, _ := strconv.Atoi(fromID)
+			changedGroups[gid] = true
+			delete(grs, gid)
+			var gr group
+			if snapshot != nil && json.Unmarshal(snapshot, &gr) == nil {
+				changedGroups[gr.GroupID] = true
+				grs[gr.GroupID] = gr
+			}
+		}
+	}
+	var broken []string
+	for id, t := range ts {
+		if _, ok := grs[t.GroupID]; !ok && (changedTasks[id] || changedGroups[t.GroupID]) {
+			broken = append(broken, "task "+id+" needs group "+strconv.Itoa(t.GroupID))
+		}
+		if _, ok := ts[t.ParentTaskID]; t.ParentTaskID != "" && !ok && (changedTasks[id] || changedTasks[t.ParentTaskID]) {
+			broken = append(broken, "task "+id+" needs parent task "+t.ParentTaskID)
+		}
+	}
+	for id, gr := range grs {
+		if _, ok := grs[gr.ParentID]; gr.ParentID != 0 && !ok && (changedGroups[id] || changedGroups[gr.ParentID]) {
+			broken = append(broken, "group "+strconv.Itoa(id)+" needs parent group "+strconv.Itoa(gr.ParentID))
+		}
+	}
+	if broken != nil {
+		sort.Strings(broken)
+		return errors.New("broken references: " + strings.Join(broken, ", "))
+	}
+	return nil
+}
+
+func undoHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	log.WithFields(log.Fields{"method": r.Method, "url": r.URL.String(), "body": r.Body}).Info("undoHandler started")
+	undo := strings.HasSuffix(r.URL.Path, "/undo")
+	action := "undo"
+	stack := getUndoStack(undoScope(r))
+	from, to := &stack.Undo, &stack.Redo
+	if !undo {
+		action = "redo"
+		from, to = &stack.Redo, &stack.Undo
+	}
+	if len(*from) == 0 {
+		http.Error(w, "400 nothing to "+action, http.StatusBadRequest)
+		log.Warn("Undo stack is empty.")
+		return
+	}
+	cs := (*from)[len(*from)-1]
+	if !checkChangeSetAccess(w, r, cs, undo) {
+		return
+	}
+	err := applyChangeSet(cs, undo)
+	if err != nil {
+		http.Error(w, "409 "+err.Error(), http.StatusConflict)
+		log.Warn("Undo conflict: ", err.Error())
+		return
+	}
+	*from = (*from)[:len(*from)-1]
+	*to = append(*to, cs)
+	err = json.NewEncoder(w).Encode(cs)
+	end := time.Now()
+	execTime := end.Sub(start)
+	if err != nil {
+		log.WithFields(log.Fields{"execution time": execTime}).Fatal("undoHandler ended: " + err.Error())
+	}
+	log.WithFields(log.Fields{"execution time": execTime}).Info("undoHandler ended")
+}
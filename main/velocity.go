@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type velocityWeek struct {
+	Start     string  `json:"start"`
+	End       string  `json:"end"`
+	Completed float64 `json:"completed_estimate"`
+	Tasks     int     `json:"completed_tasks"`
+}
+
+type velocityReport struct {
+	Unit    string         `json:"unit"`
+	Weeks   []velocityWeek `json:"weeks"`
+	Average float64        `json:"average"`
+}
+
+type forecast struct {
+	GroupID        int     `json:"group_id"`
+	Unit           string  `json:"unit"`
+	OpenTasks      int     `json:"open_tasks"`
+	Unestimated    int     `json:"unestimated_tasks"`
+	Remaining      float64 `json:"remaining_estimate"`
+	Velocity       float64 `json:"velocity"`
+	WeeksRemaining float64 `json:"weeks_remaining"`
+	ForecastDate   string  `json:"forecast_date,omitempty"`
+}
+
+func getGroupSubtree(grs []group, id int) []int {
+	ids := []int{id}
+	children := getChildren(grs, id)
+	for i := 0; i < len(children); i++ {
+		ids = append(ids, getGroupSubtree(grs, children[i].GroupID)...)
+	}
+	return ids
+}
+
+func getTasksByGroupIDs(ts []task, ids []int) []task {
+	var newTasks []task
+	for i := 0; i < len(ids); i++ {
+		newTasks = append(newTasks, getTasksByGroupID(ts, ids[i])...)
+	}
+	return newTasks
+}
+
+func getVelocity(ts []task, weeks int, n time.Time) velocityReport {
+	report := velocityReport{Unit: config.GetString("Tasks.estimate_unit"), Weeks: []velocityWeek{}}
+	var total float64
+	for i := weeks - 1; i >= 0; i-- {
+		periodStart, periodEnd, _ := getPeriod("week", n.AddDate(0, 0, -7*i))
+		week := velocityWeek{Start: periodStart.Format(time.RFC3339), End: periodEnd.Format(time.RFC3339)}
+		for g := 0; g < len(ts); g++ {
+			if !ts[g].Completed {
+				continue
+			}
+			completedDate, err := time.Parse(time.RFC3339Nano, ts[g].CompletedDate)
+			if err == nil && completedDate.After(periodStart) && !completedDate.After(periodEnd) {
+				week.Completed += ts[g].Estimate
+				week.Tasks++
+			}
+		}
+		total += week.Completed
+		report.Weeks = append(report.Weeks, week)
+	}
+	if weeks > 0 {
+		report.Average = total / float64(weeks)
+	}
+	return report
+}
+
+func getForecast(ts []task, groupID int, weeks int, n time.Time) forecast {
+	f := forecast{GroupID: groupID, Unit: config.GetString("Tasks.estimate_unit")}
+	subtree := getTasksByGroupIDs(ts, getGroupSubtree(taskGroups, groupID))
+	for i := 0; i < len(subtree); i++ {
+		if subtree[i].Completed {
+			continue
+		}
+		f.OpenTasks++
+		f.Remaining += subtree[i].Estimate
+		if subtree[i].Estimate == 0 {
+			f.Unestimated++
+		}
+	}
+	f.Velocity = getVelocity(subtree, weeks, n).Average
+	if f.Velocity > 0 {
+		f.WeeksRemaining = math.Round(f.Remaining/f.Velocity*10) / 10
+		if d := f.Remaining / f.Velocity * float64(7*24*time.Hour); d < math.MaxInt64 {
+			f.ForecastDate = n.Add(time.Duration(d)).Format("2006-01-02")
+		}
+	}
+	return f
+}
+
+func getVelocityWeeks(r *http.Request) (int, error) {
+	weeks, err := strconv.Atoi(r.URL.Query().Get("weeks"))
+	if err != nil || weeks <= 0 {
+		weeks = config.GetInt("Velocity.weeks")
+	}
+	if max := config.GetInt("Velocity.max_weeks"); max > 0 && weeks > max {
+		return 0, fmt.Errorf("weeks can not be greater than %d", max)
+	}
+	return weeks, nil
+}
+
+func velocityHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	log.WithFields(log.Fields{"method": r.Method, "url": r.URL.String(), "body": r.Body}).Info("velocityHandler started")
+	ts := tasks
+	if g := r.URL.Query().Get("group"); g != "" {
+		ID, err := strconv.Atoi(g)
+		if err != nil || !containsGroup(taskGroups, ID) {
+			http.NotFound(w, r)
+			return
+		}
+		ts = getTasksByGroupIDs(tasks, getGroupSubtree(taskGroups, ID))
+	}
+	weeks, err := getVelocityWeeks(r)
+	if err != nil {
+		http.Error(w, "400 "+err.Error(), http.StatusBadRequest)
+		log.Error("Velocity weeks: ", err.Error())
+		return
+	}
+	err = json.NewEncoder(w).Encode(getVelocity(ts, weeks, time.Now()))
+	end := time.Now()
+	execTime := end.Sub(start)
+	if err != nil {
+		log.WithFields(log.Fields{"execution time": execTime}).Fatal("velocityHandler ended: " + err.Error())
+	}
+	log.WithFields(log.Fields{"execution time": execTime}).Info("velocityHandler ended")
+}
+
+func forecastHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	log.WithFields(log.Fields{"method": r.Method, "url": r.URL.String(), "body": r.Body}).Info("forecastHandler started")
+	vars := mux.Vars(r)
+	ID, err := strconv.Atoi(vars["id"])
+	if err != nil || !containsGroup(taskGroups, ID) {
+		http.NotFound(w, r)
+		return
+	}
+	weeks, err := getVelocityWeeks(r)
+	if err != nil {
+		http.Error(w, "400 "+err.Error(), http.StatusBadRequest)
+		log.Error("Velocity weeks: ", err.Error())
+		return
+	}
+	err = json.NewEncoder(w).Encode(getForecast(tasks, ID, weeks, time.Now()))
+	end := time.Now()
+	execTime := end.Sub(start)
+	if err != nil {
+		log.WithFields(log.Fields{"execution time": execTime}).Fatal("forecastHandler ended: " + err.Error())
+	}
+	log.WithFields(log.Fields{"execution time": execTime}).Info("forecastHandler ended")
+}
@@ -17,6 +17,7 @@ import (
 	"os"
 	"os/signal"
 	"strconv"
+	"sync"
 	"time"
 )
 
@@ -25,20 +26,49 @@ type group struct {
 	Description string `json:"group_description"`
 	GroupID     int    `json:"group_id"`
 	ParentID    int    `json:"parent_id"`
+	DeletedAt   string `json:"deleted_at,omitempty"`
 }
 
 type task struct {
-	TaskID        string `json:"task_id"`
-	GroupID       int    `json:"group_id"`
-	Task          string `json:"task"`
-	Completed     bool   `json:"completed"`
-	CreatedDate   string `json:"created_at"`
-	CompletedDate string `json:"completed_at"`
+	TaskID        string            `json:"task_id"`
+	GroupID       int               `json:"group_id"`
+	ParentTaskID  string            `json:"parent_task_id,omitempty"`
+	Task          string            `json:"task"`
+	Checklist     []checklistItem   `json:"checklist,omitempty"`
+	BlockedBy     []string          `json:"blocked_by,omitempty"`
+	AssigneeIDs   []string          `json:"assignee_ids,omitempty"`
+	Completed     bool              `json:"completed"`
+	State         string            `json:"state"`
+	StateDates    map[string]string `json:"state_dates,omitempty"`
+	Position      int               `json:"position"`
+	Estimate      float64           `json:"estimate,omitempty"`
+	DueDate       string            `json:"due_at,omitempty"`
+	Archived      bool              `json:"archived,omitempty"`
+	ArchivedDate  string            `json:"archived_at,omitempty"`
+	DeletedAt     string            `json:"deleted_at,omitempty"`
+	CreatedDate   string            `json:"created_at"`
+	CompletedDate string            `json:"completed_at"`
+}
+
+type checklistItem struct {
+	Text string `json:"text"`
+	Done bool   `json:"done"`
+}
+
+type taskView struct {
+	task
+	Progress   int   `json:"progress"`
+	Blocked    bool  `json:"blocked"`
+	LoggedTime int64 `json:"logged_time"`
 }
 
 type statistics struct {
-	Completed int
-	Created   int
+	Completed         int
+	Created           int
+	States            map[string]int
+	LoggedTime        int64
+	LoggedTimeByGroup map[int]int64
+	ByAssignee        map[string]statistics `json:",omitempty"`
 }
 
 var taskGroups = readGroups()
@@ -47,6 +77,10 @@ var tasks = readTasks()
 
 var config = readConfig()
 
+var counterMu sync.Mutex
+
+var counters = readCounters()
+
 func readConfig() *viper.Viper {
 	config := viper.New()
 	config.SetConfigName("config")
@@ -73,49 +107,83 @@ func readGroups() []group {
 	return groups
 }
 
-func writeGroups(grs []group) {
-	groupsFile, err := json.Marshal(grs)
+func readTasks() []task {
+	tasksFile, err := ioutil.ReadFile("tasks.json")
 	if err != nil {
 		log.Fatal(err)
 	}
-	err = ioutil.WriteFile("groups.json", groupsFile, 0644)
+	var newTasks []task
+	err = json.Unmarshal(tasksFile, &newTasks)
 	if err != nil {
 		log.Fatal(err)
 	}
-	log.Info("groups successfully wrote")
+	return newTasks
 }
 
-func readTasks() []task {
-	tasksFile, err := ioutil.ReadFile("tasks.json")
+func readJSONFile(name string, v interface{}) {
+	file, err := ioutil.ReadFile(name)
+	if os.IsNotExist(err) {
+		return
+	}
 	if err != nil {
 		log.Fatal(err)
 	}
-	var newTasks []task
-	err = json.Unmarshal(tasksFile, &newTasks)
+	err = json.Unmarshal(file, v)
 	if err != nil {
 		log.Fatal(err)
 	}
-	return newTasks
 }
 
-func writeTasks(ts []task) {
-	tasksFile, err := json.Marshal(ts)
+func writeJSONFile(name string, v interface{}) {
+	file, err := json.Marshal(v)
 	if err != nil {
 		log.Fatal(err)
 	}
-	err = ioutil.WriteFile("tasks.json", tasksFile, 0644)
+	err = ioutil.WriteFile(name, file, 0644)
 	if err != nil {
 		log.Fatal(err)
 	}
 }
 
+func readCounters() map[string]int {
+	c := map[string]int{}
+	readJSONFile("counters.json", &c)
+	return c
+}
+
+func nextID(kind string, last int) int {
+	counterMu.Lock()
+	defer counterMu.Unlock()
+	if counters[kind] < last {
+		counters[kind] = last
+	}
+	counters[kind]++
+	return counters[kind]
+}
+
+func writeCounters() {
+	counterMu.Lock()
+	writeJSONFile("counters.json", counters)
+	counterMu.Unlock()
+}
+
 func groupsListHandler(w http.ResponseWriter, r *http.Request) {
 	start := time.Now()
 	l := r.URL.Query().Get("limit")
 	s := r.URL.Query().Get("sort")
 	log.WithFields(log.Fields{"method": r.Method, "url": r.URL.String(), "params": log.Fields{"limit": l, "sort": s}, "body": r.Body}).Info("groupsListHandler started")
-	newGroups := getSortedGroups(taskGroups, s, l)
-	err := json.NewEncoder(w).Encode(newGroups)
+	grs := taskGroups
+	asOf, ok, err := parseAsOf(r)
+	if err != nil {
+		http.Error(w, "400 "+err.Error(), http.StatusBadRequest)
+		log.Error("Parsing as_of: ", err.Error())
+		return
+	}
+	if ok {
+		grs = getGroupsAsOf(asOf)
+	}
+	newGroups := getSortedGroups(grs, s, l)
+	err = json.NewEncoder(w).Encode(newGroups)
 	end := time.Now()
 	execTime := end.Sub(start).Nanoseconds()
 	if err != nil {
@@ -306,28 +374,13 @@ func groupEditHandler(w http.ResponseWriter, r *http.Request) {
 		log.Error("Decoding group from request body: ", err.Error())
 		return
 	}
-	if containsGroup(taskGroups, gr.GroupID) && gr.GroupID != ID {
-		http.Error(w, "400 group with this ID already exists", http.StatusBadRequest)
-		log.WithField("Group ID: ", ID).Warn("Group already exists.")
-		return
-	}
-	if getChildren(taskGroups, ID) != nil && gr.GroupID != ID {
-		http.Error(w, "400 has dependent groups", http.StatusBadRequest)
-		log.WithField("Group ID: ", ID).Warn("Group has dependent groups.")
-		return
-	}
-	if getTasksByGroupID(tasks, ID) != nil && gr.GroupID != ID {
-		http.Error(w, "400 has dependent tasks", http.StatusBadRequest)
-		log.WithField("Group ID: ", ID).Warn("Group has dependent tasks.")
-		return
-	}
-	if !containsGroup(taskGroups, gr.ParentID) && gr.ParentID != 0 {
-		http.Error(w, "400 parent with this ID does not exist", http.StatusBadRequest)
-		log.WithField("Parent ID: ", ID).Warn("Parent does not exist.")
+	gr, err = updateGroup(r, ID, gr)
+	if err != nil {
+		code := getErrorCode(err)
+		http.Error(w, strconv.Itoa(code)+" "+err.Error(), code)
+		log.WithField("Group ID: ", ID).Warn("Group: ", err.Error())
 		return
 	}
-	n := getGroupNumByID(taskGroups, ID)
-	taskGroups[n] = gr
 	err = json.NewEncoder(w).Encode(gr)
 	end := time.Now()
 	execTime := end.Sub(start)
@@ -337,6 +390,30 @@ func groupEditHandler(w http.ResponseWriter, r *http.Request) {
 	log.WithFields(log.Fields{"execution time": execTime}).Info("groupEditHandler ended")
 }
 
+func updateGroup(r *http.Request, id int, gr group) (group, error) {
+	if containsGroup(taskGroups, gr.GroupID) && gr.GroupID != id {
+		return gr, errors.New("group with this ID already exists")
+	}
+	if getChildren(taskGroups, id) != nil && gr.GroupID != id {
+		return gr, errors.New("has dependent groups")
+	}
+	if getTasksByGroupID(tasks, id) != nil && gr.GroupID != id {
+		return gr, errors.New("has dependent tasks")
+	}
+	if !containsGroup(taskGroups, gr.ParentID) && gr.ParentID != 0 {
+		return gr, errors.New("parent with this ID does not exist")
+	}
+	n := getGroupNumByID(taskGroups, id)
+	if gr.ParentID != taskGroups[n].ParentID {
+		err := checkGroupRole(r, gr.ParentID, "editor")
+		if err != nil {
+			return gr, err
+		}
+	}
+	taskGroups[n] = gr
+	return gr, nil
+}
+
 func getGroupNumByID(grs []group, id int) int {
 	var n int
 	for i := 0; i < len(grs); i++ {
@@ -382,6 +459,7 @@ func removeGroup(grs []group, id int) ([]group, error) {
 	}
 	for i := 0; i < len(grs); i++ {
 		if grs[i].GroupID == id {
+			trashGroup(grs[i])
 			for g := i; g < len(grs)-1; g++ {
 				grs[g] = grs[g+1]
 			}
@@ -402,23 +480,13 @@ func newGroupHandler(w http.ResponseWriter, r *http.Request) {
 		log.Error("Decoding group from request body: ", err.Error())
 		return
 	}
-	if gr.Name == "" {
-		http.Error(w, "400 name is not specified", http.StatusBadRequest)
-		log.Error("Group name is not specified.")
-		return
-	}
-	defParID := config.GetInt("Groups.default_parent")
-	if gr.ParentID == 0 {
-		gr.ParentID = defParID
-		log.Warn("Parent ID is not specified. Default parent ID used.")
-	}
-	if !containsGroup(taskGroups, gr.ParentID) && gr.ParentID != defParID {
-		http.Error(w, "400 parent with this ID does not exist", http.StatusBadRequest)
-		log.Error("Parent does not exist.")
+	gr, err = createGroup(r, gr)
+	if err != nil {
+		code := getErrorCode(err)
+		http.Error(w, strconv.Itoa(code)+" "+err.Error(), code)
+		log.Error("Group: ", err.Error())
 		return
 	}
-	gr.GroupID = getMaxID(taskGroups) + 1
-	taskGroups = append(taskGroups, gr)
 	err = json.NewEncoder(w).Encode(gr)
 	end := time.Now()
 	execTime := end.Sub(start)
@@ -428,6 +496,36 @@ func newGroupHandler(w http.ResponseWriter, r *http.Request) {
 	log.WithFields(log.Fields{"execution time": execTime}).Info("newGroupHandler ended")
 }
 
+func createGroup(r *http.Request, gr group) (group, error) {
+	err := validateNewGroup(taskGroups, &gr)
+	if err != nil {
+		return gr, err
+	}
+	err = checkGroupRole(r, gr.ParentID, "editor")
+	if err != nil {
+		return gr, err
+	}
+	gr.GroupID = getNextGroupID(taskGroups)
+	taskGroups = append(taskGroups, gr)
+	grantCreator(r, gr.GroupID, gr.ParentID)
+	return gr, nil
+}
+
+func validateNewGroup(grs []group, gr *group) error {
+	if gr.Name == "" {
+		return errors.New("name is not specified")
+	}
+	defParID := config.GetInt("Groups.default_parent")
+	if gr.ParentID == 0 {
+		gr.ParentID = defParID
+		log.Warn("Parent ID is not specified. Default parent ID used.")
+	}
+	if !containsGroup(grs, gr.ParentID) && gr.ParentID != defParID {
+		return errors.New("parent with this ID does not exist")
+	}
+	return nil
+}
+
 func getMaxID(grs []group) int {
 	max := 0
 	for i := 0; i < len(grs); i++ {
@@ -438,6 +536,14 @@ func getMaxID(grs []group) int {
 	return max
 }
 
+func getNextGroupID(grs []group) int {
+	id := getMaxID(grs) + 1
+	if getMaxID(trash.Groups) >= id {
+		id = getMaxID(trash.Groups) + 1
+	}
+	return id
+}
+
 func getTasksByGroupID(t []task, id int) []task {
 	var newTasks []task
 	for i := 0; i < len(t); i++ {
@@ -454,8 +560,22 @@ func tasksListHandler(w http.ResponseWriter, r *http.Request) {
 	s := r.URL.Query().Get("sort")
 	t := r.URL.Query().Get("type")
 	log.WithFields(log.Fields{"method": r.Method, "url": r.URL.String(), "params": log.Fields{"limit": l, "sort": s, "type": t}, "body": r.Body}).Info("tasksListHandler started")
-	newTasks := getSortedTasks(tasks, s, l, t)
-	err := json.NewEncoder(w).Encode(newTasks)
+	ts := tasks
+	asOf, ok, err := parseAsOf(r)
+	if err != nil {
+		http.Error(w, "400 "+err.Error(), http.StatusBadRequest)
+		log.Error("Parsing as_of: ", err.Error())
+		return
+	}
+	if ok {
+		ts = getTasksAsOf(asOf)
+	}
+	newTasks := ts
+	if r.URL.Query().Get("include_archived") != "true" {
+		newTasks = getUnarchivedTasks(newTasks)
+	}
+	newTasks = getSortedTasks(newTasks, s, l, t)
+	err = json.NewEncoder(w).Encode(decorateTasks(ts, newTasks))
 	end := time.Now()
 	execTime := end.Sub(start).Nanoseconds()
 	if err != nil {
@@ -476,9 +596,13 @@ func getSortedTasks(ts []task, s string, l string, t string) []task {
 	}
 	switch t {
 	case "completed":
-		newTasks = getCompletedTasks(ts)
+		newTasks = getCompletedTasks(newTasks)
 	case "working":
-		newTasks = getWorkingTasks(ts)
+		newTasks = getWorkingTasks(newTasks)
+	default:
+		if isWorkflowState(t) {
+			newTasks = filterTasksByState(newTasks, t)
+		}
 	}
 	lim, err := strconv.Atoi(l)
 	if err != nil || lim < 0 {
@@ -522,21 +646,23 @@ func sortTasksByGroup(ts []task) []task {
 }
 
 func getCompletedTasks(ts []task) []task {
+	var newTasks []task
 	for i := 0; i < len(ts); i++ {
-		if !ts[i].Completed {
-			ts = removeTask(ts, i)
+		if isTerminalState(ts[i].State) {
+			newTasks = append(newTasks, ts[i])
 		}
 	}
-	return ts
+	return newTasks
 }
 
 func getWorkingTasks(ts []task) []task {
+	var newTasks []task
 	for i := 0; i < len(ts); i++ {
-		if ts[i].Completed {
-			ts = removeTask(ts, i)
+		if !isTerminalState(ts[i].State) {
+			newTasks = append(newTasks, ts[i])
 		}
 	}
-	return ts
+	return newTasks
 }
 
 func removeTask(ts []task, n int) []task {
@@ -557,50 +683,125 @@ func newTaskHandler(w http.ResponseWriter, r *http.Request) {
 		log.Error("Decoding task from request body: ", err.Error())
 		return
 	}
-	if t.Task == "" {
-		http.Error(w, "400 task is not specified", http.StatusBadRequest)
-		log.Error("Task is not specified.")
+	t, err = createTask(r, t)
+	if err != nil {
+		code := getErrorCode(err)
+		http.Error(w, strconv.Itoa(code)+" "+err.Error(), code)
+		log.Error("Task: ", err.Error())
 		return
 	}
-	if t.GroupID == 0 {
-		t.GroupID = config.GetInt("Tasks.default_group")
-		log.Warn("Group ID is not specified. Default group ID used.")
+	err = json.NewEncoder(w).Encode(decorateTask(tasks, t))
+	end := time.Now()
+	execTime := end.Sub(start)
+	if err != nil {
+		log.WithFields(log.Fields{"execution time": execTime}).Fatal("newTaskHandler ended: " + err.Error())
 	}
-	if !containsGroup(taskGroups, t.GroupID) {
-		http.Error(w, "400 group with this ID does not exist", http.StatusBadRequest)
-		log.Error("Group does not exist.")
-		return
+	log.WithFields(log.Fields{"execution time": execTime}).Info("newTaskHandler ended")
+}
+
+func createTask(r *http.Request, t task) (task, error) {
+	err := validateNewTask(tasks, &t)
+	if err != nil {
+		return t, err
+	}
+	err = checkGroupRole(r, t.GroupID, "editor")
+	if err != nil {
+		return t, err
 	}
 	idLim := config.GetInt("Tasks.tasks_length")
 	hash := sha1.New()
 	hash.Write([]byte(t.Task))
 	t.TaskID = hex.EncodeToString(hash.Sum(nil))[:idLim]
 	if containsTask(tasks, t.TaskID) {
-		http.Error(w, "400 task with this ID already exists", http.StatusBadRequest)
-		log.Error("Task already exists.")
-		return
+		return t, errors.New("task with this ID already exists")
 	}
+	mark := len(activity)
+	tasks, err = addTask(tasks, t, nil)
+	if err != nil {
+		return t, err
+	}
+	setActivityActor(mark, requestActor(r))
+	return tasks[getTaskNumByID(tasks, t.TaskID)], nil
+}
+
+func validateNewTask(ts []task, t *task) error {
+	if t.Task == "" {
+		return errors.New("task is not specified")
+	}
+	if t.Estimate < 0 {
+		return errors.New("estimate can not be negative")
+	}
+	if err := validateDueDate(t.DueDate); err != nil {
+		return err
+	}
+	if err := validateParentTask(ts, t, ""); err != nil {
+		return err
+	}
+	if err := validateBlockers(ts, "", t.BlockedBy); err != nil {
+		return err
+	}
+	if err := validateAssignees(t.AssigneeIDs, nil); err != nil {
+		return err
+	}
+	if t.GroupID == 0 {
+		t.GroupID = config.GetInt("Tasks.default_group")
+		log.Warn("Group ID is not specified. Default group ID used.")
+	}
+	if !containsGroup(taskGroups, t.GroupID) {
+		return errors.New("group with this ID does not exist")
+	}
+	return nil
+}
+
+func validateDueDate(date string) error {
+	if date == "" {
+		return nil
+	}
+	if _, err := time.Parse(time.RFC3339, date); err != nil {
+		return errors.New("due date must be in RFC3339 format")
+	}
+	return nil
+}
+
+func addTask(ts []task, t task, details map[string]string) ([]task, error) {
 	t.CreatedDate = time.Now().Format(time.RFC3339Nano)
-	tasks = append(tasks, t)
-	err = json.NewEncoder(w).Encode(t)
-	end := time.Now()
-	execTime := end.Sub(start)
+	t.Completed = false
+	t.CompletedDate = ""
+	t.State = initialState()
+	t.StateDates = map[string]string{t.State: t.CreatedDate}
+	err := checkParentCompletion(withTasks(ts, []task{t}), t.ParentTaskID)
 	if err != nil {
-		log.WithFields(log.Fields{"execution time": execTime}).Fatal("newTaskHandler ended: " + err.Error())
+		return ts, err
 	}
-	log.WithFields(log.Fields{"execution time": execTime}).Info("newTaskHandler ended")
+	ts = append(ts, t)
+	logActivity(t.TaskID, "created", details)
+	logAssigneeChanges(t.TaskID, nil, t.AssigneeIDs)
+	return syncParentCompletion(ts, t.ParentTaskID)
 }
 
 func groupTasksHandler(w http.ResponseWriter, r *http.Request) {
 	start := time.Now()
 	log.WithFields(log.Fields{"method": r.Method, "url": r.URL.String(), "body": r.Body}).Info("groupTasksHandler started")
 	vars := mux.Vars(r)
+	grs, ts := taskGroups, tasks
+	asOf, ok, err := parseAsOf(r)
+	if err != nil {
+		http.Error(w, "400 "+err.Error(), http.StatusBadRequest)
+		log.Error("Parsing as_of: ", err.Error())
+		return
+	}
+	if ok {
+		grs, ts = getGroupsAsOf(asOf), getTasksAsOf(asOf)
+	}
 	ID, err := strconv.Atoi(vars["id"])
-	if err != nil || !containsGroup(taskGroups, ID) {
+	if err != nil || !containsGroup(grs, ID) {
 		http.NotFound(w, r)
 		return
 	}
-	newTasks := getTasksByGroupID(tasks, ID)
+	newTasks := getTasksByGroupID(ts, ID)
+	if r.URL.Query().Get("include_archived") != "true" {
+		newTasks = getUnarchivedTasks(newTasks)
+	}
 	if newTasks == nil {
 		http.Error(w, "400 has no dependent tasks", http.StatusBadRequest)
 		log.Error("Group has no dependent tasks")
@@ -612,13 +813,17 @@ func groupTasksHandler(w http.ResponseWriter, r *http.Request) {
 		newTasks = getCompletedTasks(newTasks)
 	case "working":
 		newTasks = getWorkingTasks(newTasks)
+	default:
+		if isWorkflowState(t) {
+			newTasks = filterTasksByState(newTasks, t)
+		}
 	}
 	if len(newTasks) == 0 {
 		http.Error(w, "400 has no dependent tasks of this type", http.StatusBadRequest)
 		log.Error("Group has no dependent tasks of this type")
 		return
 	}
-	err = json.NewEncoder(w).Encode(newTasks)
+	err = json.NewEncoder(w).Encode(decorateTasks(ts, newTasks))
 	end := time.Now()
 	execTime := end.Sub(start)
 	if err != nil {
@@ -636,53 +841,45 @@ func taskHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	n := getTaskNumByID(tasks, vars["id"])
 	f := r.URL.Query().Get("finished")
+	force := r.URL.Query().Get("force") == "true"
+	st := r.URL.Query().Get("state")
 	log.WithFields(log.Fields{"method": r.Method, "url": r.URL.String(), "body": r.Body}).Info("taskHandler started")
 	var t task
 	var err error
+	mark := len(activity)
 	switch f {
 	case "true":
-		tasks, err = changeTaskType(tasks, vars["id"], true)
+		tasks, err = changeTaskType(tasks, vars["id"], true, force)
 	case "false":
-		tasks, err = changeTaskType(tasks, vars["id"], false)
+		tasks, err = changeTaskType(tasks, vars["id"], false, force)
 	case "":
+		if st != "" {
+			tasks, err = transitionTask(tasks, vars["id"], st, force)
+			break
+		}
 		err = json.NewDecoder(r.Body).Decode(&t)
 		if err != nil {
 			http.Error(w, "400 "+err.Error(), http.StatusBadRequest)
 			log.Error("Decoding task from request body: ", err.Error())
 			return
 		}
-		if t.Task == "" {
-			http.Error(w, "400 task is not specified", http.StatusBadRequest)
-			log.Error("Task is not specified.")
-			return
-		}
-		if !containsGroup(taskGroups, t.GroupID) {
-			http.Error(w, "400 group with this ID does not exist", http.StatusBadRequest)
-			log.Error("Group does not exist.")
-			return
-		}
-		hash := sha1.New()
-		hash.Write([]byte(t.Task))
-		t.TaskID = hex.EncodeToString(hash.Sum(nil))[:5]
-		if containsTask(tasks, t.TaskID) {
-			http.Error(w, "400 task with this ID already exists", http.StatusBadRequest)
-			return
+		t, err = updateTask(r, vars["id"], t)
+		if err == nil {
+			n = getTaskNumByID(tasks, t.TaskID)
 		}
-		t.Completed = tasks[n].Completed
-		t.CreatedDate = tasks[n].CreatedDate
-		t.CompletedDate = tasks[n].CompletedDate
-		tasks[n] = t
 	default:
 		http.Error(w, "400 bad request", http.StatusBadRequest)
 		log.Error("Invalid query.")
 		return
 	}
 	if err != nil {
-		http.Error(w, "400 "+err.Error(), http.StatusBadRequest)
+		code := getErrorCode(err)
+		http.Error(w, strconv.Itoa(code)+" "+err.Error(), code)
 		log.Error("Task is ", err.Error())
 		return
 	}
-	err = json.NewEncoder(w).Encode(tasks[n])
+	setActivityActor(mark, requestActor(r))
+	err = json.NewEncoder(w).Encode(decorateTask(tasks, tasks[n]))
 	end := time.Now()
 	execTime := end.Sub(start)
 	if err != nil {
@@ -691,6 +888,99 @@ func taskHandler(w http.ResponseWriter, r *http.Request) {
 	log.WithFields(log.Fields{"execution time": execTime}).Info("taskHandler ended")
 }
 
+func updateTask(r *http.Request, id string, t task) (task, error) {
+	n := getTaskNumByID(tasks, id)
+	if t.Task == "" {
+		return t, errors.New("task is not specified")
+	}
+	if t.Estimate < 0 {
+		return t, errors.New("estimate can not be negative")
+	}
+	err := validateDueDate(t.DueDate)
+	if err != nil {
+		return t, err
+	}
+	err = validateParentTask(tasks, &t, id)
+	if err != nil {
+		return t, err
+	}
+	err = validateAssignees(t.AssigneeIDs, tasks[n].AssigneeIDs)
+	if err != nil {
+		return t, err
+	}
+	if !containsGroup(taskGroups, t.GroupID) {
+		return t, errors.New("group with this ID does not exist")
+	}
+	if t.GroupID != tasks[n].GroupID {
+		err = checkGroupRole(r, t.GroupID, "editor")
+		if err != nil {
+			return t, err
+		}
+		err = checkGroupWIPLimits(tasks, t.GroupID, getTaskTree(tasks, id))
+		if err != nil {
+			return t, err
+		}
+	}
+	if t.ParentTaskID != tasks[n].ParentTaskID {
+		moved := tasks[n]
+		moved.GroupID = t.GroupID
+		moved.ParentTaskID = t.ParentTaskID
+		next := withTasks(tasks, []task{moved})
+		err = checkParentCompletion(next, tasks[n].ParentTaskID)
+		if err != nil {
+			return t, err
+		}
+		err = checkParentCompletion(next, t.ParentTaskID)
+		if err != nil {
+			return t, err
+		}
+	}
+	t.TaskID = id
+	if t.Task != tasks[n].Task {
+		hash := sha1.New()
+		hash.Write([]byte(t.Task))
+		t.TaskID = hex.EncodeToString(hash.Sum(nil))[:config.GetInt("Tasks.tasks_length")]
+	}
+	if containsTask(tasks, t.TaskID) && t.TaskID != id {
+		return t, errors.New("task with this ID already exists")
+	}
+	mark := len(activity)
+	old := tasks[n]
+	t.Completed = old.Completed
+	t.CreatedDate = old.CreatedDate
+	t.CompletedDate = old.CompletedDate
+	t.State = old.State
+	t.StateDates = old.StateDates
+	t.Position = old.Position
+	t.Archived = old.Archived
+	t.ArchivedDate = old.ArchivedDate
+	t.BlockedBy = old.BlockedBy
+	tasks[n] = t
+	if t.TaskID != old.TaskID {
+		tasks = renameTaskReferences(tasks, old.TaskID, t.TaskID)
+	}
+	if t.Task != old.Task {
+		logActivity(t.TaskID, "renamed", map[string]string{"from": old.Task, "to": t.Task})
+	}
+	logAssigneeChanges(t.TaskID, old.AssigneeIDs, t.AssigneeIDs)
+	if t.GroupID != old.GroupID {
+		logActivity(t.TaskID, "moved", map[string]string{"from_group": strconv.Itoa(old.GroupID), "to_group": strconv.Itoa(t.GroupID)})
+		tasks = moveSubtasks(tasks, t.TaskID, t.GroupID)
+	}
+	if t.ParentTaskID != old.ParentTaskID {
+		tasks, err = syncParentCompletion(tasks, old.ParentTaskID)
+		if err != nil {
+			return t, err
+		}
+		tasks, err = syncParentCompletion(tasks, t.ParentTaskID)
+		if err != nil {
+			return t, err
+		}
+	}
+	setActivityActor(mark, requestActor(r))
+	return tasks[getTaskNumByID(tasks, t.TaskID)], nil
+}
+
 func getTaskNumByID(ts []task, id string) int {
 	var n int
 	for i := 0; i < len(ts); i++ {
@@ -702,19 +992,22 @@ func getTaskNumByID(ts []task, id string) int {
 	return n
 }
 
-func changeTaskType(ts []task, id string, t bool) ([]task, error) {
+func changeTaskType(ts []task, id string, t bool, force bool) ([]task, error) {
 	for i := 0; i < len(ts); i++ {
 		if ts[i].TaskID == id {
 			if t == ts[i].Completed {
 				return ts, errors.New("already of this type")
 			}
-			ts[i].Completed = t
-			if t {
-				ts[i].CompletedDate = time.Now().Format(time.RFC3339Nano)
-			} else {
-				ts[i].CompletedDate = ""
+			if !t {
+				return transitionTask(ts, id, initialState(), force)
 			}
-			break
+			states := terminalStates()
+			for j := 0; j < len(states); j++ {
+				if canTransition(ts[i].State, states[j]) {
+					return transitionTask(ts, id, states[j], force)
+				}
+			}
+			return transitionTask(ts, id, states[0], force)
 		}
 	}
 	return ts, nil
@@ -738,6 +1031,19 @@ func statHandler(w http.ResponseWriter, r *http.Request) {
 		http.NotFound(w, r)
 		return
 	}
+	switch r.URL.Query().Get("by") {
+	case "":
+	case "assignee":
+		err = addAssigneeStat(&stat, tasks, vars["period"])
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+	default:
+		http.Error(w, "400 unknown breakdown", http.StatusBadRequest)
+		log.Error("Invalid stat breakdown.")
+		return
+	}
 	err = json.NewEncoder(w).Encode(stat)
 	end := time.Now()
 	execTime := end.Sub(start)
@@ -747,9 +1053,7 @@ func statHandler(w http.ResponseWriter, r *http.Request) {
 	log.WithFields(log.Fields{"execution time": execTime}).Info("statHandler ended")
 }
 
-func getStat(ts []task, period string) (statistics, error) {
-	var s statistics
-	n := time.Now()
+func getPeriod(period string, n time.Time) (time.Time, time.Time, error) {
 	var periodStart time.Time
 	var periodEnd time.Time
 	switch period {
@@ -767,11 +1071,20 @@ func getStat(ts []task, period string) (statistics, error) {
 		periodStart = time.Date(n.Year(), n.Month()-1, n.Day(), n.Hour(), n.Minute(), n.Second(), n.Nanosecond(), n.Location())
 		periodEnd = n
 	default:
-		return statistics{0, 0}, errors.New("not found")
+		return periodStart, periodEnd, errors.New("not found")
+	}
+	return periodStart, periodEnd, nil
+}
+
+func getStat(ts []task, period string) (statistics, error) {
+	s := statistics{States: map[string]int{}, LoggedTimeByGroup: map[int]int64{}}
+	n := time.Now()
+	periodStart, periodEnd, err := getPeriod(period, n)
+	if err != nil {
+		return statistics{}, err
 	}
 	var createdDate time.Time
 	var completedDate time.Time
-	var err error
 	for i := 0; i < len(ts); i++ {
 		createdDate, err = time.Parse(time.RFC3339Nano, ts[i].CreatedDate)
 		if err != nil {
@@ -791,29 +1104,121 @@ func getStat(ts []task, period string) (statistics, error) {
 		if completedDate.Before(periodEnd) && completedDate.After(periodStart) {
 			s.Completed++
 		}
+		for state, date := range ts[i].StateDates {
+			stateDate, err := time.Parse(time.RFC3339Nano, date)
+			if err == nil && stateDate.Before(periodEnd) && stateDate.After(periodStart) {
+				s.States[state]++
+			}
+		}
 	}
+	addLoggedTimeStat(&s, ts, periodStart, periodEnd)
 	return s, nil
 }
 
-func main() {
-	log.SetOutput(os.Stdout)
-	port := config.GetString("Application.Port")
-	var wait time.Duration
-	flag.DurationVar(&wait, "graceful-timeout", time.Second*15, "the duration for which the server gracefully wait for existing connections to finish - e.g. 15s or 1m")
-	flag.Parse()
-	r := mux.NewRouter()
+func registerRoutes(r *mux.Router) {
 	r.HandleFunc("/groups", groupsListHandler).Methods("GET")
 	r.HandleFunc("/groups/top_parents", topParentsHandler).Methods("GET")
 	r.HandleFunc("/groups/children/{id:[0-9]+}", groupsChildrenHandler).Methods("GET")
 	r.HandleFunc("/groups/new", newGroupHandler).Methods("POST")
+	r.HandleFunc("/groups/bulk", groupsBulkHandler).Methods("POST")
 	r.HandleFunc("/groups/{id:[0-9]+}", groupShowHandler).Methods("GET")
 	r.HandleFunc("/groups/{id:[0-9]+}", groupEditHandler).Methods("PUT")
 	r.HandleFunc("/groups/{id:[0-9]+}", groupDeleteHandler).Methods("DELETE")
+	r.HandleFunc("/events", eventsHandler).Methods("GET")
+	r.HandleFunc("/ws", wsHandler).Methods("GET")
 	r.HandleFunc("/tasks", tasksListHandler).Methods("GET")
+	r.HandleFunc("/me/tasks", myTasksHandler).Methods("GET")
 	r.HandleFunc("/tasks/new", newTaskHandler).Methods("POST")
+	r.HandleFunc("/tasks/bulk", tasksBulkHandler).Methods("POST")
+	r.HandleFunc("/tasks/move", tasksMoveHandler).Methods("POST")
 	r.HandleFunc("/tasks/group/{id:[0-9]+}", groupTasksHandler).Methods("GET")
 	r.HandleFunc("/tasks/{id:[a-zA-Z0-9]+}", taskHandler).Methods("PUT")
+	r.HandleFunc("/tasks/{id:[a-zA-Z0-9]+}", taskDeleteHandler).Methods("DELETE")
+	r.HandleFunc("/tasks/{id:[a-zA-Z0-9]+}/move", taskMoveHandler).Methods("POST")
+	r.HandleFunc("/tasks/{id:[a-zA-Z0-9]+}/duplicate", taskDuplicateHandler).Methods("POST")
+	r.HandleFunc("/tasks/{id:[a-zA-Z0-9]+}/subtasks", subtasksHandler).Methods("GET")
+	r.HandleFunc("/tasks/{id:[a-zA-Z0-9]+}/checklist/{item:[0-9]+}", checklistItemHandler).Methods("PUT")
+	r.HandleFunc("/tasks/{id:[a-zA-Z0-9]+}/dependencies", dependenciesHandler).Methods("GET")
+	r.HandleFunc("/tasks/{id:[a-zA-Z0-9]+}/dependencies", newDependencyHandler).Methods("POST")
+	r.HandleFunc("/tasks/{id:[a-zA-Z0-9]+}/dependencies/{blocker:[a-zA-Z0-9]+}", dependencyDeleteHandler).Methods("DELETE")
+	r.HandleFunc("/groups/{id:[0-9]+}/dependencies", dependencyGraphHandler).Methods("GET")
+	r.HandleFunc("/tasks/{id:[a-zA-Z0-9]+}/comments", commentsHandler).Methods("GET")
+	r.HandleFunc("/tasks/{id:[a-zA-Z0-9]+}/comments", newCommentHandler).Methods("POST")
+	r.HandleFunc("/tasks/{id:[a-zA-Z0-9]+}/comments/{comment:[0-9]+}", commentEditHandler).Methods("PUT")
+	r.HandleFunc("/tasks/{id:[a-zA-Z0-9]+}/comments/{comment:[0-9]+}", commentDeleteHandler).Methods("DELETE")
+	r.HandleFunc("/tasks/{id:[a-zA-Z0-9]+}/activity", activityHandler).Methods("GET")
+	r.HandleFunc("/tasks/{id:[a-zA-Z0-9]+}/attachments", attachmentsHandler).Methods("GET")
+	r.HandleFunc("/tasks/{id:[a-zA-Z0-9]+}/attachments", newAttachmentHandler).Methods("POST")
+	r.HandleFunc("/tasks/{id:[a-zA-Z0-9]+}/attachments/{attachment:[0-9]+}", attachmentDownloadHandler).Methods("GET")
+	r.HandleFunc("/tasks/{id:[a-zA-Z0-9]+}/attachments/{attachment:[0-9]+}", attachmentDeleteHandler).Methods("DELETE")
+	r.HandleFunc("/tasks/{id:[a-zA-Z0-9]+}/timer/{action:start|stop}", timerHandler).Methods("POST")
+	r.HandleFunc("/tasks/{id:[a-zA-Z0-9]+}/worklog", worklogHandler).Methods("GET")
+	r.HandleFunc("/tasks/{id:[a-zA-Z0-9]+}/worklog", newWorklogHandler).Methods("POST")
+	r.HandleFunc("/tasks/{id:[a-zA-Z0-9]+}/worklog/{worklog:[0-9]+}", worklogDeleteHandler).Methods("DELETE")
+	r.HandleFunc("/groups/{id:[0-9]+}/forecast", forecastHandler).Methods("GET")
+	r.HandleFunc("/groups/{id:[0-9]+}/board", boardHandler).Methods("GET")
+	r.HandleFunc("/groups/{id:[0-9]+}/board/move", boardMoveHandler).Methods("POST")
+	r.HandleFunc("/stat/velocity", velocityHandler).Methods("GET")
 	r.HandleFunc("/stat/{period}", statHandler).Methods("GET")
+	r.HandleFunc("/tasks/{id:[a-zA-Z0-9]+}/history", taskHistoryHandler).Methods("GET")
+	r.HandleFunc("/tasks/{id:[a-zA-Z0-9]+}/archive", archiveHandler).Methods("POST")
+	r.HandleFunc("/tasks/{id:[a-zA-Z0-9]+}/unarchive", archiveHandler).Methods("POST")
+	r.HandleFunc("/groups/{id:[0-9]+}/history", groupHistoryHandler).Methods("GET")
+	r.HandleFunc("/groups/{id:[0-9]+}/clone", groupCloneHandler).Methods("POST")
+	r.HandleFunc("/groups/{id:[0-9]+}/grants", grantsHandler).Methods("GET")
+	r.HandleFunc("/groups/{id:[0-9]+}/grants/{username}", grantHandler).Methods("PUT")
+	r.HandleFunc("/groups/{id:[0-9]+}/grants/{username}", grantDeleteHandler).Methods("DELETE")
+	r.HandleFunc("/webhooks", webhooksHandler).Methods("GET")
+	r.HandleFunc("/webhooks", newWebhookHandler).Methods("POST")
+	r.HandleFunc("/webhooks/dead_letters", deadLettersHandler).Methods("GET")
+	r.HandleFunc("/webhooks/deliveries/{delivery:[0-9]+}/retry", deliveryRetryHandler).Methods("POST")
+	r.HandleFunc("/webhooks/{id:[0-9]+}", webhookShowHandler).Methods("GET")
+	r.HandleFunc("/webhooks/{id:[0-9]+}", webhookEditHandler).Methods("PUT")
+	r.HandleFunc("/webhooks/{id:[0-9]+}", webhookDeleteHandler).Methods("DELETE")
+	r.HandleFunc("/webhooks/{id:[0-9]+}/ping", webhookPingHandler).Methods("POST")
+	r.HandleFunc("/webhooks/{id:[0-9]+}/deliveries", webhookDeliveriesHandler).Methods("GET")
+	r.HandleFunc("/trash", trashHandler).Methods("GET")
+	r.HandleFunc("/trash/tasks/{id:[a-zA-Z0-9]+}/restore", restoreTaskHandler).Methods("POST")
+	r.HandleFunc("/trash/groups/{id:[0-9]+}/restore", restoreGroupHandler).Methods("POST")
+	r.HandleFunc("/templates", templatesHandler).Methods("GET")
+	r.HandleFunc("/templates/{name:[a-zA-Z0-9_-]+}/instantiate", templateInstantiateHandler).Methods("POST")
+	r.HandleFunc("/undo", undoHandler).Methods("POST")
+	r.HandleFunc("/redo", undoHandler).Methods("POST")
+}
+
+func main() {
+	log.SetOutput(os.Stdout)
+	port := config.GetString("Application.Port")
+	var wait time.Duration
+	flag.DurationVar(&wait, "graceful-timeout", time.Second*15, "the duration for which the server gracefully wait for existing connections to finish - e.g. 15s or 1m")
+	flag.Parse()
+	tasks = normalizeTaskStates(tasks)
+	addBaselineRevisions()
+	initWorkspaces()
+	addBootstrapAdmin()
+	r := mux.NewRouter()
+	r.HandleFunc("/login", loginHandler).Methods("POST")
+	r.HandleFunc("/logout", logoutHandler).Methods("POST")
+	r.HandleFunc("/me", meHandler).Methods("GET")
+	r.HandleFunc("/me/password", passwordHandler).Methods("PUT")
+	r.HandleFunc("/me/tokens", tokensHandler).Methods("GET")
+	r.HandleFunc("/me/tokens", newTokenHandler).Methods("POST")
+	r.HandleFunc("/me/tokens/{token:[0-9]+}", tokenDeleteHandler).Methods("DELETE")
+	r.HandleFunc("/users", usersHandler).Methods("GET")
+	r.HandleFunc("/users", newUserHandler).Methods("POST")
+	r.HandleFunc("/users/{username}", userDeleteHandler).Methods("DELETE")
+	r.HandleFunc("/workspaces", workspacesHandler).Methods("GET")
+	r.HandleFunc("/workspaces", newWorkspaceHandler).Methods("POST")
+	r.HandleFunc("/workspaces/{name:[a-z0-9_-]+}", workspaceEditHandler).Methods("PUT")
+	r.HandleFunc("/workspaces/{name:[a-z0-9_-]+}/members/{username}", workspaceMemberHandler).Methods("PUT", "DELETE")
+	r.HandleFunc("/audit", auditHandler).Methods("GET")
+	r.HandleFunc("/audit/export", auditExportHandler).Methods("GET")
+	registerRoutes(r)
+	registerRoutes(r.PathPrefix(workspacePrefix).Subrouter())
+	r.Use(requestIDMiddleware)
+	r.Use(authMiddleware)
+	r.Use(storeMiddleware)
+	r.Use(accessMiddleware)
 	http.Handle("/", r)
 	srv := &http.Server{
 		Addr:         "0.0.0.0:" + port,
@@ -822,6 +1227,10 @@ func main() {
 		IdleTimeout:  time.Second * 60,
 		Handler:      r,
 	}
+	srv.RegisterOnShutdown(closeEventSubscribers)
+	go runTrashPurge()
+	go runArchivePolicy()
+	go runWebhookWorker()
 	go func() {
 		if err := srv.ListenAndServe(); err != nil {
 			log.Println(err)
@@ -836,8 +1245,15 @@ func main() {
 	if err != nil {
 		log.Fatal(err)
 	}
-	writeGroups(taskGroups)
-	writeTasks(tasks)
+	writeWorkspaces()
+	writeJSONFile("users.json", users)
+	flushRejectedAudits()
+	writeCounters()
+	writeJSONFile("tokens.json", apiTokens)
+	webhookMu.Lock()
+	writeJSONFile("webhooks.json", webhooks)
+	writeJSONFile("webhook_deliveries.json", webhookDeliveries)
+	webhookMu.Unlock()
 	log.Println("shutting down")
 	os.Exit(0)
 }
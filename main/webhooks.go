@@ -0,0 +1,702 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type webhook struct {
+	WebhookID   int      `json:"webhook_id"`
+	Workspace   string   `json:"workspace"`
+	URL         string   `json:"url"`
+	Secret      string   `json:"secret,omitempty"`
+	Events      []string `json:"events"`
+	GroupIDs    []int    `json:"group_ids,omitempty"`
+	Paused      bool     `json:"paused"`
+	CreatedBy   string   `json:"created_by"`
+	CreatedDate string   `json:"created_at"`
+}
+
+type webhookDelivery struct {
+	DeliveryID    int             `json:"delivery_id"`
+	WebhookID     int             `json:"webhook_id"`
+	Workspace     string          `json:"workspace"`
+	Event         string          `json:"event"`
+	Payload       json.RawMessage `json:"payload"`
+	Status        string          `json:"status"`
+	Attempts      int             `json:"attempts"`
+	ResponseCode  int             `json:"response_code,omitempty"`
+	LastError     string          `json:"last_error,omitempty"`
+	NextAttempt   string          `json:"next_attempt_at,omitempty"`
+	CreatedDate   string          `json:"created_at"`
+	DeliveredDate string          `json:"delivered_at,omitempty"`
+}
+
+type changeEvent struct {
+	Event      string                 `json:"event"`
+	Workspace  string                 `json:"workspace"`
+	Actor      string                 `json:"actor"`
+	Resource   string                 `json:"resource,omitempty"`
+	ResourceID string                 `json:"resource_id,omitempty"`
+	GroupID    int                    `json:"group_id,omitempty"`
+	Changes    map[string]fieldChange `json:"changes,omitempty"`
+	Data       json.RawMessage        `json:"data,omitempty"`
+	OccurredAt string                 `json:"occurred_at"`
+}
+
+var webhookEvents = []string{"task.created", "task.updated", "task.completed", "task.reopened", "task.deleted", "task.purged", "group.created", "group.updated", "group.deleted", "group.purged"}
+
+var webhookMu sync.Mutex
+
+var webhooks = readWebhooks()
+
+var webhookDeliveries = readWebhookDeliveries()
+
+var webhookClient = &http.Client{}
+
+var webhookWake = make(chan struct{}, 1)
+
+var webhookBusy = map[int]bool{}
+
+func readWebhooks() []webhook {
+	var whs []webhook
+	readJSONFile("webhooks.json", &whs)
+	return whs
+}
+
+func readWebhookDeliveries() []webhookDelivery {
+	var ds []webhookDelivery
+	readJSONFile("webhook_deliveries.json", &ds)
+	return ds
+}
+
+func getWebhookNum(id int) int {
+	for i := 0; i < len(webhooks); i++ {
+		if webhooks[i].WebhookID == id && webhooks[i].Workspace == activeWorkspace {
+			return i
+		}
+	}
+	return -1
+}
+
+func getDeliveryNum(id int) int {
+	for i := 0; i < len(webhookDeliveries); i++ {
+		if webhookDeliveries[i].DeliveryID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func validateWebhook(wh *webhook) error {
+	u, err := url.Parse(wh.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("url must be an absolute http or https URL")
+	}
+	if len(wh.Events) == 0 {
+		return errors.New("events are not specified")
+	}
+	for i := 0; i < len(wh.Events); i++ {
+		if wh.Events[i] != "*" && !containsString(webhookEvents, wh.Events[i]) {
+			return errors.New("unknown event " + wh.Events[i])
+		}
+	}
+	for i := 0; i < len(wh.GroupIDs); i++ {
+		if !containsGroup(taskGroups, wh.GroupIDs[i]) {
+			return errors.New("group with this ID does not exist")
+		}
+	}
+	if wh.Secret == "" {
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return err
+		}
+		wh.Secret = hex.EncodeToString(secret)
+	}
+	return nil
+}
+
+func getChangeEvents(actor string, changes []recordChange) []changeEvent {
+	now := time.Now().Format(time.RFC3339Nano)
+	var events []changeEvent
+	for i := 0; i < len(changes); i++ {
+		c := changes[i]
+		e := changeEvent{Workspace: activeWorkspace, Actor: actor, Resource: c.Resource, OccurredAt: now}
+		action := "updated"
+		e.ResourceID, e.Data = c.AfterID, c.After
+		switch {
+		case c.Before == nil:
+			action = "created"
+		case c.After == nil:
+			action = "deleted"
+			e.ResourceID, e.Data = c.BeforeID, c.Before
+		default:
+			e.Changes = diffRecords(c.Before, c.After)
+		}
+		if c.Action != "" {
+			action = c.Action
+		}
+		var record struct {
+			GroupID int `json:"group_id"`
+		}
+		_ = json.Unmarshal(e.Data, &record)
+		e.GroupID = record.GroupID
+		e.Event = c.Resource + "." + action
+		events = append(events, e)
+		if completed, ok := e.Changes["completed"]; ok && c.Resource == "task" {
+			e.Event = "task.reopened"
+			if completed.New == true {
+				e.Event = "task.completed"
+			}
+			events = append(events, e)
+		}
+	}
+	return events
+}
+
+func publishChanges(actor string, changes []recordChange) {
+	if len(changes) == 0 {
+		return
+	}
+	events := getChangeEvents(actor, changes)
+	broadcastEvents(events)
+	enqueueWebhooks(events)
+}
+
+func matchesWebhook(wh webhook, e changeEvent, known []group) bool {
+	if !containsString(wh.Events, "*") && !containsString(wh.Events, e.Event) {
+		return false
+	}
+	if len(wh.GroupIDs) == 0 {
+		return true
+	}
+	for i := 0; i < len(wh.GroupIDs); i++ {
+		if containsInt(getGroupSubtree(known, wh.GroupIDs[i]), e.GroupID) {
+			return true
+		}
+	}
+	return false
+}
+
+func addDelivery(wh webhook, e changeEvent) {
+	d := webhookDelivery{WebhookID: wh.WebhookID, Workspace: wh.Workspace, Event: e.Event, Status: "pending"}
+	d.CreatedDate = time.Now().Format(time.RFC3339Nano)
+	d.NextAttempt = d.CreatedDate
+	d.Payload, _ = json.Marshal(e)
+	last := 0
+	if len(webhookDeliveries) != 0 {
+		last = webhookDeliveries[len(webhookDeliveries)-1].DeliveryID
+	}
+	d.DeliveryID = nextID("webhook_delivery", last)
+	webhookDeliveries = append(webhookDeliveries, d)
+}
+
+func enqueueWebhooks(events []changeEvent) {
+	webhookMu.Lock()
+	defer webhookMu.Unlock()
+	known := getKnownGroups()
+	queued := false
+	for i := 0; i < len(webhooks); i++ {
+		if webhooks[i].Workspace != activeWorkspace || webhooks[i].Paused {
+			continue
+		}
+		for j := 0; j < len(events); j++ {
+			if matchesWebhook(webhooks[i], events[j], known) {
+				addDelivery(webhooks[i], events[j])
+				queued = true
+			}
+		}
+	}
+	if queued {
+		wakeWebhookWorker()
+	}
+}
+
+func wakeWebhookWorker() {
+	select {
+	case webhookWake <- struct{}{}:
+	default:
+	}
+}
+
+func signPayload(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func sendWebhook(wh webhook, d webhookDelivery) (int, error) {
+	req, err := http.NewRequest("POST", wh.URL, bytes.NewReader(d.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Webhook-Event", d.Event)
+	req.Header.Set("X-Webhook-Delivery", strconv.Itoa(d.DeliveryID))
+	req.Header.Set("X-Webhook-Signature", "sha256="+signPayload(wh.Secret, d.Payload))
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("receiver responded with status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+func getWebhookBackoff(attempts int) time.Duration {
+	backoff, err := time.ParseDuration(baseConfig.GetString("Webhooks.retry_backoff"))
+	if err != nil || backoff <= 0 {
+		backoff = 10 * time.Second
+	}
+	maxBackoff, err := time.ParseDuration(baseConfig.GetString("Webhooks.max_backoff"))
+	if err != nil || maxBackoff <= 0 {
+		maxBackoff = time.Hour
+	}
+	for i := 1; i < attempts && backoff < maxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxBackoff {
+		backoff = maxBackoff
+	}
+	return backoff
+}
+
+func finishDelivery(id int, code int, err error, now time.Time) {
+	n := getDeliveryNum(id)
+	if n < 0 {
+		return
+	}
+	d := &webhookDeliveries[n]
+	d.Attempts++
+	d.ResponseCode = code
+	if err == nil {
+		d.Status = "delivered"
+		d.DeliveredDate = now.Format(time.RFC3339Nano)
+		d.NextAttempt = ""
+		d.LastError = ""
+		trimDeliveries(d.WebhookID)
+		return
+	}
+	d.LastError = err.Error()
+	log.WithFields(log.Fields{"Webhook ID: ": d.WebhookID, "Delivery ID: ": d.DeliveryID}).Warn("Delivering webhook: ", err.Error())
+	if d.Attempts >= baseConfig.GetInt("Webhooks.max_attempts") {
+		d.Status = "dead"
+		d.NextAttempt = ""
+		return
+	}
+	d.NextAttempt = now.Add(getWebhookBackoff(d.Attempts)).Format(time.RFC3339Nano)
+}
+
+func trimDeliveries(webhookID int) {
+	limit := baseConfig.GetInt("Webhooks.log_limit")
+	if limit <= 0 {
+		return
+	}
+	kept := 0
+	var ds []webhookDelivery
+	for i := len(webhookDeliveries) - 1; i >= 0; i-- {
+		d := webhookDeliveries[i]
+		if d.WebhookID == webhookID && d.Status == "delivered" {
+			kept++
+			if kept > limit {
+				continue
+			}
+		}
+		ds = append([]webhookDelivery{d}, ds...)
+	}
+	webhookDeliveries = ds
+}
+
+func isPausedWebhook(id int) bool {
+	for i := 0; i < len(webhooks); i++ {
+		if webhooks[i].WebhookID == id {
+			return webhooks[i].Paused
+		}
+	}
+	return true
+}
+
+func sendDeliveries(wh webhook, ds []webhookDelivery) {
+	for i := 0; i < len(ds); i++ {
+		code, err := sendWebhook(wh, ds[i])
+		webhookMu.Lock()
+		finishDelivery(ds[i].DeliveryID, code, err, time.Now())
+		webhookMu.Unlock()
+	}
+	webhookMu.Lock()
+	delete(webhookBusy, wh.WebhookID)
+	webhookMu.Unlock()
+	wakeWebhookWorker()
+}
+
+func deliverDueWebhooks(now time.Time) time.Duration {
+	webhookMu.Lock()
+	defer webhookMu.Unlock()
+	limit := baseConfig.GetInt("Webhooks.concurrency")
+	if limit <= 0 {
+		limit = 1
+	}
+	due := map[int][]webhookDelivery{}
+	var targets []webhook
+	for i := 0; i < len(webhookDeliveries); i++ {
+		d := webhookDeliveries[i]
+		next, err := time.Parse(time.RFC3339Nano, d.NextAttempt)
+		if d.Status != "pending" || err != nil || next.After(now) || webhookBusy[d.WebhookID] || isPausedWebhook(d.WebhookID) {
+			continue
+		}
+		if due[d.WebhookID] == nil {
+			for j := 0; j < len(webhooks); j++ {
+				if webhooks[j].WebhookID == d.WebhookID {
+					targets = append(targets, webhooks[j])
+				}
+			}
+		}
+		due[d.WebhookID] = append(due[d.WebhookID], d)
+	}
+	for i := 0; i < len(targets) && len(webhookBusy) < limit; i++ {
+		webhookBusy[targets[i].WebhookID] = true
+		go sendDeliveries(targets[i], due[targets[i].WebhookID])
+	}
+	wait := time.Minute
+	if len(webhookBusy) >= limit {
+		return wait
+	}
+	for i := 0; i < len(webhookDeliveries); i++ {
+		d := webhookDeliveries[i]
+		next, err := time.Parse(time.RFC3339Nano, d.NextAttempt)
+		if d.Status == "pending" && err == nil && next.Sub(time.Now()) < wait && !webhookBusy[d.WebhookID] && !isPausedWebhook(d.WebhookID) {
+			wait = next.Sub(time.Now())
+		}
+	}
+	return wait
+}
+
+func runWebhookWorker() {
+	timeout, err := time.ParseDuration(baseConfig.GetString("Webhooks.timeout"))
+	if err == nil && timeout > 0 {
+		webhookClient.Timeout = timeout
+	}
+	for {
+		wait := deliverDueWebhooks(time.Now())
+		if wait <= 0 {
+			continue
+		}
+		select {
+		case <-webhookWake:
+		case <-time.After(wait):
+		}
+	}
+}
+
+func getWebhookView(wh webhook) webhook {
+	wh.Secret = ""
+	return wh
+}
+
+func webhooksHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	log.WithFields(log.Fields{"method": r.Method, "url": r.URL.String()}).Info("webhooksHandler started")
+	if !requireAdmin(w, r) {
+		return
+	}
+	webhookMu.Lock()
+	whs := []webhook{}
+	for i := 0; i < len(webhooks); i++ {
+		if webhooks[i].Workspace == activeWorkspace {
+			whs = append(whs, getWebhookView(webhooks[i]))
+		}
+	}
+	webhookMu.Unlock()
+	err := json.NewEncoder(w).Encode(whs)
+	end := time.Now()
+	execTime := end.Sub(start)
+	if err != nil {
+		log.WithFields(log.Fields{"execution time": execTime}).Fatal("webhooksHandler ended: " + err.Error())
+	}
+	log.WithFields(log.Fields{"execution time": execTime}).Info("webhooksHandler ended")
+}
+
+func newWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	log.WithFields(log.Fields{"method": r.Method, "url": r.URL.String()}).Info("newWebhookHandler started")
+	if !requireAdmin(w, r) {
+		return
+	}
+	var wh webhook
+	err := json.NewDecoder(r.Body).Decode(&wh)
+	if err != nil {
+		http.Error(w, "400 "+err.Error(), http.StatusBadRequest)
+		log.Error("Decoding webhook from request body: ", err.Error())
+		return
+	}
+	err = validateWebhook(&wh)
+	if err != nil {
+		http.Error(w, "400 "+err.Error(), http.StatusBadRequest)
+		log.Error("Webhook: ", err.Error())
+		return
+	}
+	webhookMu.Lock()
+	wh.WebhookID = 1
+	for i := 0; i < len(webhooks); i++ {
+		if webhooks[i].WebhookID >= wh.WebhookID {
+			wh.WebhookID = webhooks[i].WebhookID + 1
+		}
+	}
+	wh.Workspace = activeWorkspace
+	wh.CreatedBy = requestActor(r)
+	wh.CreatedDate = time.Now().Format(time.RFC3339Nano)
+	webhooks = append(webhooks, wh)
+	webhookMu.Unlock()
+	err = json.NewEncoder(w).Encode(wh)
+	end := time.Now()
+	execTime := end.Sub(start)
+	if err != nil {
+		log.WithFields(log.Fields{"execution time": execTime}).Fatal("newWebhookHandler ended: " + err.Error())
+	}
+	log.WithFields(log.Fields{"execution time": execTime}).Info("newWebhookHandler ended")
+}
+
+func webhookShowHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	log.WithFields(log.Fields{"method": r.Method, "url": r.URL.String()}).Info("webhookShowHandler started")
+	if !requireAdmin(w, r) {
+		return
+	}
+	vars := mux.Vars(r)
+	ID, _ := strconv.Atoi(vars["id"])
+	webhookMu.Lock()
+	n := getWebhookNum(ID)
+	var wh webhook
+	if n >= 0 {
+		wh = getWebhookView(webhooks[n])
+	}
+	webhookMu.Unlock()
+	if n < 0 {
+		http.NotFound(w, r)
+		return
+	}
+	err := json.NewEncoder(w).Encode(wh)
+	end := time.Now()
+	execTime := end.Sub(start)
+	if err != nil {
+		log.WithFields(log.Fields{"execution time": execTime}).Fatal("webhookShowHandler ended: " + err.Error())
+	}
+	log.WithFields(log.Fields{"execution time": execTime}).Info("webhookShowHandler ended")
+}
+
+func webhookEditHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	log.WithFields(log.Fields{"method": r.Method, "url": r.URL.String()}).Info("webhookEditHandler started")
+	if !requireAdmin(w, r) {
+		return
+	}
+	vars := mux.Vars(r)
+	ID, _ := strconv.Atoi(vars["id"])
+	webhookMu.Lock()
+	defer webhookMu.Unlock()
+	n := getWebhookNum(ID)
+	if n < 0 {
+		http.NotFound(w, r)
+		return
+	}
+	var wh webhook
+	err := json.NewDecoder(r.Body).Decode(&wh)
+	if err != nil {
+		http.Error(w, "400 "+err.Error(), http.StatusBadRequest)
+		log.Error("Decoding webhook from request body: ", err.Error())
+		return
+	}
+	if wh.Secret == "" {
+		wh.Secret = webhooks[n].Secret
+	}
+	err = validateWebhook(&wh)
+	if err != nil {
+		http.Error(w, "400 "+err.Error(), http.StatusBadRequest)
+		log.Error("Webhook: ", err.Error())
+		return
+	}
+	wh.WebhookID = webhooks[n].WebhookID
+	wh.Workspace = webhooks[n].Workspace
+	wh.CreatedBy = webhooks[n].CreatedBy
+	wh.CreatedDate = webhooks[n].CreatedDate
+	webhooks[n] = wh
+	if !wh.Paused {
+		wakeWebhookWorker()
+	}
+	err = json.NewEncoder(w).Encode(getWebhookView(wh))
+	end := time.Now()
+	execTime := end.Sub(start)
+	if err != nil {
+		log.WithFields(log.Fields{"execution time": execTime}).Fatal("webhookEditHandler ended: " + err.Error())
+	}
+	log.WithFields(log.Fields{"execution time": execTime}).Info("webhookEditHandler ended")
+}
+
+func webhookDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	log.WithFields(log.Fields{"method": r.Method, "url": r.URL.String()}).Info("webhookDeleteHandler started")
+	if !requireAdmin(w, r) {
+		return
+	}
+	vars := mux.Vars(r)
+	ID, _ := strconv.Atoi(vars["id"])
+	webhookMu.Lock()
+	n := getWebhookNum(ID)
+	if n >= 0 {
+		webhooks = append(webhooks[:n], webhooks[n+1:]...)
+		var ds []webhookDelivery
+		for i := 0; i < len(webhookDeliveries); i++ {
+			if webhookDeliveries[i].WebhookID != ID {
+				ds = append(ds, webhookDeliveries[i])
+			}
+		}
+		webhookDeliveries = ds
+	}
+	webhookMu.Unlock()
+	if n < 0 {
+		http.NotFound(w, r)
+		return
+	}
+	_, err := fmt.Fprint(w, "webhook deleted")
+	end := time.Now()
+	execTime := end.Sub(start)
+	if err != nil {
+		log.WithFields(log.Fields{"execution time": execTime}).Fatal("webhookDeleteHandler ended: " + err.Error())
+	}
+	log.WithFields(log.Fields{"execution time": execTime}).Info("webhookDeleteHandler ended")
+}
+
+func webhookPingHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	log.WithFields(log.Fields{"method": r.Method, "url": r.URL.String()}).Info("webhookPingHandler started")
+	if !requireAdmin(w, r) {
+		return
+	}
+	vars := mux.Vars(r)
+	ID, _ := strconv.Atoi(vars["id"])
+	webhookMu.Lock()
+	n := getWebhookNum(ID)
+	var d webhookDelivery
+	if n >= 0 {
+		addDelivery(webhooks[n], changeEvent{Event: "ping", Workspace: activeWorkspace, Actor: requestActor(r), OccurredAt: time.Now().Format(time.RFC3339Nano)})
+		d = webhookDeliveries[len(webhookDeliveries)-1]
+		wakeWebhookWorker()
+	}
+	webhookMu.Unlock()
+	if n < 0 {
+		http.NotFound(w, r)
+		return
+	}
+	err := json.NewEncoder(w).Encode(d)
+	end := time.Now()
+	execTime := end.Sub(start)
+	if err != nil {
+		log.WithFields(log.Fields{"execution time": execTime}).Fatal("webhookPingHandler ended: " + err.Error())
+	}
+	log.WithFields(log.Fields{"execution time": execTime}).Info("webhookPingHandler ended")
+}
+
+func getDeliveries(webhookID int, status string) []webhookDelivery {
+	ds := []webhookDelivery{}
+	for i := 0; i < len(webhookDeliveries); i++ {
+		d := webhookDeliveries[i]
+		if d.Workspace == activeWorkspace && (webhookID == 0 || d.WebhookID == webhookID) && (status == "" || d.Status == status) {
+			ds = append(ds, d)
+		}
+	}
+	return ds
+}
+
+func webhookDeliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	log.WithFields(log.Fields{"method": r.Method, "url": r.URL.String()}).Info("webhookDeliveriesHandler started")
+	if !requireAdmin(w, r) {
+		return
+	}
+	vars := mux.Vars(r)
+	ID, _ := strconv.Atoi(vars["id"])
+	webhookMu.Lock()
+	n := getWebhookNum(ID)
+	ds := getDeliveries(ID, r.URL.Query().Get("status"))
+	webhookMu.Unlock()
+	if n < 0 {
+		http.NotFound(w, r)
+		return
+	}
+	err := json.NewEncoder(w).Encode(ds)
+	end := time.Now()
+	execTime := end.Sub(start)
+	if err != nil {
+		log.WithFields(log.Fields{"execution time": execTime}).Fatal("webhookDeliveriesHandler ended: " + err.Error())
+	}
+	log.WithFields(log.Fields{"execution time": execTime}).Info("webhookDeliveriesHandler ended")
+}
+
+func deadLettersHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	log.WithFields(log.Fields{"method": r.Method, "url": r.URL.String()}).Info("deadLettersHandler started")
+	if !requireAdmin(w, r) {
+		return
+	}
+	webhookMu.Lock()
+	ds := getDeliveries(0, "dead")
+	webhookMu.Unlock()
+	err := json.NewEncoder(w).Encode(ds)
+	end := time.Now()
+	execTime := end.Sub(start)
+	if err != nil {
+		log.WithFields(log.Fields{"execution time": execTime}).Fatal("deadLettersHandler ended: " + err.Error())
+	}
+	log.WithFields(log.Fields{"execution time": execTime}).Info("deadLettersHandler ended")
+}
+
+func deliveryRetryHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	log.WithFields(log.Fields{"method": r.Method, "url": r.URL.String()}).Info("deliveryRetryHandler started")
+	if !requireAdmin(w, r) {
+		return
+	}
+	vars := mux.Vars(r)
+	ID, _ := strconv.Atoi(vars["delivery"])
+	webhookMu.Lock()
+	defer webhookMu.Unlock()
+	n := getDeliveryNum(ID)
+	if n < 0 || webhookDeliveries[n].Workspace != activeWorkspace {
+		http.NotFound(w, r)
+		return
+	}
+	if webhookDeliveries[n].Status != "dead" {
+		http.Error(w, "400 only dead deliveries can be retried", http.StatusBadRequest)
+		log.WithField("Delivery ID: ", ID).Warn("Delivery is not dead.")
+		return
+	}
+	d := &webhookDeliveries[n]
+	d.Status = "pending"
+	d.Attempts = 0
+	d.NextAttempt = time.Now().Format(time.RFC3339Nano)
+	wakeWebhookWorker()
+	err := json.NewEncoder(w).Encode(d)
+	end := time.Now()
+	execTime := end.Sub(start)
+	if err != nil {
+		log.WithFields(log.Fields{"execution time": execTime}).Fatal("deliveryRetryHandler ended: " + err.Error())
+	}
+	log.WithFields(log.Fields{"execution time": execTime}).Info("deliveryRetryHandler ended")
+}
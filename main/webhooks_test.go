@@ -0,0 +1,162 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/spf13/viper"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+type webhookReceiver struct {
+	mu       sync.Mutex
+	codes    []int
+	requests int
+	badSigns int
+	ids      []string
+}
+
+func (rc *webhookReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write(body)
+	want := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if !hmac.Equal([]byte(r.Header.Get("X-Webhook-Signature")), []byte(want)) {
+		rc.badSigns++
+	}
+	rc.ids = append(rc.ids, r.Header.Get("X-Webhook-Delivery"))
+	code := http.StatusOK
+	if rc.requests < len(rc.codes) {
+		code = rc.codes[rc.requests]
+	}
+	rc.requests++
+	w.WriteHeader(code)
+}
+
+func setTestConfig(t *testing.T, c *viper.Viper, key string, value interface{}) {
+	old := c.Get(key)
+	c.Set(key, value)
+	t.Cleanup(func() {
+		c.Set(key, old)
+	})
+}
+
+func waitWebhookWorkers(t *testing.T) {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		webhookMu.Lock()
+		busy := len(webhookBusy)
+		webhookMu.Unlock()
+		if busy == 0 {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("webhook deliveries did not finish")
+}
+
+func getTestDelivery(t *testing.T, id int) webhookDelivery {
+	webhookMu.Lock()
+	defer webhookMu.Unlock()
+	n := getDeliveryNum(id)
+	if n < 0 {
+		t.Fatalf("delivery %d not found", id)
+	}
+	return webhookDeliveries[n]
+}
+
+func getTestNextAttempt(t *testing.T, d webhookDelivery) time.Time {
+	next, err := time.Parse(time.RFC3339Nano, d.NextAttempt)
+	if err != nil {
+		t.Fatalf("delivery %d has no next attempt: %v", d.DeliveryID, err)
+	}
+	return next
+}
+
+func TestDeliverDueWebhooks(t *testing.T) {
+	savedWebhooks, savedDeliveries := webhooks, webhookDeliveries
+	t.Cleanup(func() {
+		webhooks, webhookDeliveries = savedWebhooks, savedDeliveries
+	})
+	setTestConfig(t, baseConfig, "Webhooks.max_attempts", 2)
+	setTestConfig(t, baseConfig, "Webhooks.retry_backoff", "1s")
+	setTestConfig(t, baseConfig, "Webhooks.max_backoff", "1h")
+	setTestConfig(t, baseConfig, "Webhooks.concurrency", 2)
+	rc := &webhookReceiver{codes: []int{http.StatusInternalServerError, http.StatusOK}}
+	srv := httptest.NewServer(rc)
+	defer srv.Close()
+
+	webhookMu.Lock()
+	webhooks = []webhook{{WebhookID: 1, Workspace: activeWorkspace, URL: srv.URL, Secret: "secret", Events: []string{"*"}}}
+	webhookDeliveries = nil
+	addDelivery(webhooks[0], changeEvent{Event: "task.created", Workspace: activeWorkspace, OccurredAt: time.Now().Format(time.RFC3339Nano)})
+	id := webhookDeliveries[0].DeliveryID
+	webhookMu.Unlock()
+
+	start := time.Now()
+	deliverDueWebhooks(start)
+	waitWebhookWorkers(t)
+	d := getTestDelivery(t, id)
+	if d.Status != "pending" || d.Attempts != 1 || d.ResponseCode != http.StatusInternalServerError {
+		t.Fatalf("after failed attempt got status %q, attempts %d, code %d", d.Status, d.Attempts, d.ResponseCode)
+	}
+	next := getTestNextAttempt(t, d)
+	if next.Before(start.Add(time.Second)) || next.After(time.Now().Add(time.Second)) {
+		t.Fatalf("retry scheduled at %v, want one second backoff after %v", next, start)
+	}
+
+	wait := deliverDueWebhooks(start)
+	waitWebhookWorkers(t)
+	if wait <= 0 || wait > time.Second {
+		t.Fatalf("worker waits %v before the retry, want at most one second", wait)
+	}
+	rc.mu.Lock()
+	requests := rc.requests
+	rc.mu.Unlock()
+	if requests != 1 {
+		t.Fatalf("delivery retried before its backoff, %d requests", requests)
+	}
+
+	deliverDueWebhooks(next)
+	waitWebhookWorkers(t)
+	d = getTestDelivery(t, id)
+	if d.Status != "delivered" || d.Attempts != 2 || d.NextAttempt != "" {
+		t.Fatalf("after retry got status %q, attempts %d, next attempt %q", d.Status, d.Attempts, d.NextAttempt)
+	}
+
+	rc.mu.Lock()
+	rc.codes = append(rc.codes, http.StatusInternalServerError, http.StatusInternalServerError)
+	rc.mu.Unlock()
+	webhookMu.Lock()
+	addDelivery(webhooks[0], changeEvent{Event: "task.deleted", Workspace: activeWorkspace, OccurredAt: time.Now().Format(time.RFC3339Nano)})
+	id = webhookDeliveries[len(webhookDeliveries)-1].DeliveryID
+	webhookMu.Unlock()
+	deliverDueWebhooks(time.Now())
+	waitWebhookWorkers(t)
+	deliverDueWebhooks(getTestNextAttempt(t, getTestDelivery(t, id)))
+	waitWebhookWorkers(t)
+	d = getTestDelivery(t, id)
+	if d.Status != "dead" || d.Attempts != 2 || d.NextAttempt != "" {
+		t.Fatalf("after max attempts got status %q, attempts %d, next attempt %q", d.Status, d.Attempts, d.NextAttempt)
+	}
+
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if rc.requests != 4 {
+		t.Fatalf("receiver got %d requests, want 4", rc.requests)
+	}
+	if rc.badSigns != 0 {
+		t.Fatalf("%d requests had an invalid signature", rc.badSigns)
+	}
+	if rc.ids[0] != strconv.Itoa(d.DeliveryID-1) || rc.ids[3] != strconv.Itoa(d.DeliveryID) {
+		t.Fatalf("receiver got delivery ids %v", rc.ids)
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"errors"
+	"time"
+)
+
+func workflowStates() []string {
+	states := config.GetStringSlice("Workflow.states")
+	if len(states) == 0 {
+		states = []string{"todo", "done"}
+	}
+	return states
+}
+
+func initialState() string {
+	s := config.GetString("Workflow.initial")
+	if s == "" {
+		s = workflowStates()[0]
+	}
+	return s
+}
+
+func terminalStates() []string {
+	states := config.GetStringSlice("Workflow.terminal")
+	if len(states) == 0 {
+		all := workflowStates()
+		states = all[len(all)-1:]
+	}
+	return states
+}
+
+func isWorkflowState(s string) bool {
+	return containsString(workflowStates(), s)
+}
+
+func isTerminalState(s string) bool {
+	return containsString(terminalStates(), s)
+}
+
+func canTransition(from string, to string) bool {
+	if !config.IsSet("Workflow.transitions") {
+		return true
+	}
+	return containsString(config.GetStringSlice("Workflow.transitions."+from), to)
+}
+
+func normalizeTaskStates(ts []task) []task {
+	for i := 0; i < len(ts); i++ {
+		if ts[i].State != "" && isWorkflowState(ts[i].State) {
+			continue
+		}
+		if ts[i].StateDates == nil {
+			ts[i].StateDates = map[string]string{}
+		}
+		ts[i].State = initialState()
+		ts[i].StateDates[ts[i].State] = ts[i].CreatedDate
+		if ts[i].Completed {
+			ts[i].State = terminalStates()[0]
+			ts[i].StateDates[ts[i].State] = ts[i].CompletedDate
+		}
+	}
+	return ts
+}
+
+func changeTaskState(ts []task, id string, state string, force bool) ([]task, error) {
+	if !isWorkflowState(state) {
+		return ts, errors.New("unknown state")
+	}
+	if !containsTask(ts, id) {
+		return ts, errors.New("not found")
+	}
+	n := getTaskNumByID(ts, id)
+	if ts[n].State == state {
+		return ts, errors.New("already in this state")
+	}
+	completed := isTerminalState(state)
+	if completed && !ts[n].Completed && !force && isBlocked(ts, ts[n]) {
+		return ts, errors.New("blocked by open tasks")
+	}
+	if err := checkWIPLimit(ts, ts[n].GroupID, state); err != nil {
+		return ts, err
+	}
+	if completed != ts[n].Completed {
+		t := ts[n]
+		t.State = state
+		t.Completed = completed
+		if err := checkParentCompletion(withTasks(ts, []task{t}), t.ParentTaskID); err != nil {
+			return ts, err
+		}
+	}
+	now := time.Now().Format(time.RFC3339Nano)
+	details := map[string]string{"from": ts[n].State, "to": state}
+	if ts[n].StateDates == nil {
+		ts[n].StateDates = map[string]string{}
+	}
+	ts[n].State = state
+	ts[n].StateDates[state] = now
+	if completed != ts[n].Completed {
+		ts[n].Completed = completed
+		if completed {
+			ts[n].CompletedDate = now
+			logActivity(id, "completed", details)
+		} else {
+			ts[n].CompletedDate = ""
+			ts[n].Archived = false
+			ts[n].ArchivedDate = ""
+			logActivity(id, "reopened", details)
+		}
+		return syncParentCompletion(ts, ts[n].ParentTaskID)
+	}
+	logActivity(id, "state_changed", details)
+	return ts, nil
+}
+
+func transitionTask(ts []task, id string, state string, force bool) ([]task, error) {
+	if containsTask(ts, id) && !canTransition(ts[getTaskNumByID(ts, id)].State, state) {
+		return ts, errors.New("transition is not allowed")
+	}
+	return changeTaskState(ts, id, state, force)
+}
+
+func filterTasksByState(ts []task, state string) []task {
+	var newTasks []task
+	for i := 0; i < len(ts); i++ {
+		if ts[i].State == state {
+			newTasks = append(newTasks, ts[i])
+		}
+	}
+	return newTasks
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type worklogEntry struct {
+	WorklogID   int    `json:"worklog_id"`
+	TaskID      string `json:"task_id"`
+	Author      string `json:"author"`
+	StartedDate string `json:"started_at"`
+	EndedDate   string `json:"ended_at"`
+	Duration    int64  `json:"duration"`
+	Note        string `json:"note,omitempty"`
+	Manual      bool   `json:"manual"`
+}
+
+var worklog = readWorklog()
+
+func readWorklog() []worklogEntry {
+	var entries []worklogEntry
+	readJSONFile("worklog.json", &entries)
+	return entries
+}
+
+func nextWorklogID() int {
+	last := 0
+	if len(worklog) != 0 {
+		last = worklog[len(worklog)-1].WorklogID
+	}
+	return nextID("worklog", last)
+}
+
+func getRunningTimer(author string) int {
+	for i := 0; i < len(worklog); i++ {
+		if worklog[i].Author == author && worklog[i].EndedDate == "" {
+			return i
+		}
+	}
+	return -1
+}
+
+func stopTimer(n int, now time.Time) {
+	started, _ := time.Parse(time.RFC3339Nano, worklog[n].StartedDate)
+	worklog[n].EndedDate = now.Format(time.RFC3339Nano)
+	worklog[n].Duration = int64(now.Sub(started).Seconds())
+}
+
+func startTimer(id string, author string) (worklogEntry, error) {
+	now := time.Now()
+	if n := getRunningTimer(author); n >= 0 {
+		if config.GetString("TimeTracking.second_timer") != "stop" {
+			return worklogEntry{}, errors.New("timer is already running for task " + worklog[n].TaskID)
+		}
+		stopTimer(n, now)
+	}
+	e := worklogEntry{
+		WorklogID:   nextWorklogID(),
+		TaskID:      id,
+		Author:      author,
+		StartedDate: now.Format(time.RFC3339Nano),
+	}
+	worklog = append(worklog, e)
+	return e, nil
+}
+
+func getEntryInterval(e worklogEntry, n time.Time) (time.Time, time.Time) {
+	started, _ := time.Parse(time.RFC3339Nano, e.StartedDate)
+	if e.EndedDate == "" {
+		return started, n
+	}
+	ended, _ := time.Parse(time.RFC3339Nano, e.EndedDate)
+	return started, ended
+}
+
+func getLoggedTime(id string) int64 {
+	var total int64
+	n := time.Now()
+	for i := 0; i < len(worklog); i++ {
+		if worklog[i].TaskID == id {
+			started, ended := getEntryInterval(worklog[i], n)
+			total += int64(ended.Sub(started).Seconds())
+		}
+	}
+	return total
+}
+
+func getTaskWorklog(id string) []worklogEntry {
+	entries := []worklogEntry{}
+	for i := 0; i < len(worklog); i++ {
+		if worklog[i].TaskID == id {
+			entries = append(entries, worklog[i])
+		}
+	}
+	return entries
+}
+
+func renameWorklogTask(oldID string, newID string) {
+	for i := 0; i < len(worklog); i++ {
+		if worklog[i].TaskID == oldID {
+			worklog[i].TaskID = newID
+		}
+	}
+}
+
+func removeTaskWorklog(id string) {
+	var entries []worklogEntry
+	for i := 0; i < len(worklog); i++ {
+		if worklog[i].TaskID != id {
+			entries = append(entries, worklog[i])
+		}
+	}
+	worklog = entries
+}
+
+func addLoggedTimeStat(s *statistics, ts []task, periodStart time.Time, periodEnd time.Time) {
+	n := time.Now()
+	for i := 0; i < len(worklog); i++ {
+		started, ended := getEntryInterval(worklog[i], n)
+		if started.Before(periodStart) {
+			started = periodStart
+		}
+		if ended.After(periodEnd) {
+			ended = periodEnd
+		}
+		if !ended.After(started) || !containsTask(ts, worklog[i].TaskID) {
+			continue
+		}
+		seconds := int64(ended.Sub(started).Seconds())
+		s.LoggedTime += seconds
+		s.LoggedTimeByGroup[ts[getTaskNumByID(ts, worklog[i].TaskID)].GroupID] += seconds
+	}
+}
+
+func timerHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	log.WithFields(log.Fields{"method": r.Method, "url": r.URL.String(), "body": r.Body}).Info("timerHandler started")
+	vars := mux.Vars(r)
+	if !containsTask(tasks, vars["id"]) {
+		http.NotFound(w, r)
+		return
+	}
+	actor := requestActor(r)
+	var e worklogEntry
+	var err error
+	switch vars["action"] {
+	case "start":
+		e, err = startTimer(vars["id"], actor)
+		if err != nil {
+			http.Error(w, "409 "+err.Error(), http.StatusConflict)
+			log.WithField("Task ID: ", vars["id"]).Warn("Timer: ", err.Error())
+			return
+		}
+	case "stop":
+		n := getRunningTimer(actor)
+		if n < 0 || worklog[n].TaskID != vars["id"] {
+			http.Error(w, "400 timer is not running", http.StatusBadRequest)
+			log.WithField("Task ID: ", vars["id"]).Warn("Timer is not running.")
+			return
+		}
+		stopTimer(n, time.Now())
+		e = worklog[n]
+	}
+	err = json.NewEncoder(w).Encode(e)
+	end := time.Now()
+	execTime := end.Sub(start)
+	if err != nil {
+		log.WithFields(log.Fields{"execution time": execTime}).Fatal("timerHandler ended: " + err.Error())
+	}
+	log.WithFields(log.Fields{"execution time": execTime}).Info("timerHandler ended")
+}
+
+func worklogHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	log.WithFields(log.Fields{"method": r.Method, "url": r.URL.String(), "body": r.Body}).Info("worklogHandler started")
+	vars := mux.Vars(r)
+	if !containsTask(tasks, vars["id"]) {
+		http.NotFound(w, r)
+		return
+	}
+	err := json.NewEncoder(w).Encode(getTaskWorklog(vars["id"]))
+	end := time.Now()
+	execTime := end.Sub(start)
+	if err != nil {
+		log.WithFields(log.Fields{"execution time": execTime}).Fatal("worklogHandler ended: " + err.Error())
+	}
+	log.WithFields(log.Fields{"execution time": execTime}).Info("worklogHandler ended")
+}
+
+func newWorklogHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	log.WithFields(log.Fields{"method": r.Method, "url": r.URL.String(), "body": r.Body}).Info("newWorklogHandler started")
+	vars := mux.Vars(r)
+	if !containsTask(tasks, vars["id"]) {
+		http.NotFound(w, r)
+		return
+	}
+	var body struct {
+		StartedDate string `json:"started_at"`
+		Duration    string `json:"duration"`
+		Note        string `json:"note"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&body)
+	if err != nil {
+		http.Error(w, "400 "+err.Error(), http.StatusBadRequest)
+		log.Error("Decoding worklog entry from request body: ", err.Error())
+		return
+	}
+	d, err := time.ParseDuration(body.Duration)
+	if err != nil || d <= 0 {
+		http.Error(w, "400 duration is not specified", http.StatusBadRequest)
+		log.Error("Worklog duration is not specified.")
+		return
+	}
+	started := time.Now().Add(-d)
+	if body.StartedDate != "" {
+		started, err = time.Parse(time.RFC3339Nano, body.StartedDate)
+		if err != nil {
+			http.Error(w, "400 "+err.Error(), http.StatusBadRequest)
+			log.Error("Parsing worklog start: ", err.Error())
+			return
+		}
+	}
+	e := worklogEntry{
+		WorklogID:   nextWorklogID(),
+		TaskID:      vars["id"],
+		Author:      requestActor(r),
+		StartedDate: started.Format(time.RFC3339Nano),
+		EndedDate:   started.Add(d).Format(time.RFC3339Nano),
+		Duration:    int64(d.Seconds()),
+		Note:        body.Note,
+		Manual:      true,
+	}
+	worklog = append(worklog, e)
+	err = json.NewEncoder(w).Encode(e)
+	end := time.Now()
+	execTime := end.Sub(start)
+	if err != nil {
+		log.WithFields(log.Fields{"execution time": execTime}).Fatal("newWorklogHandler ended: " + err.Error())
+	}
+	log.WithFields(log.Fields{"execution time": execTime}).Info("newWorklogHandler ended")
+}
+
+func worklogDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	log.WithFields(log.Fields{"method": r.Method, "url": r.URL.String(), "body": r.Body}).Info("worklogDeleteHandler started")
+	vars := mux.Vars(r)
+	ID, err := strconv.Atoi(vars["worklog"])
+	n := -1
+	for i := 0; i < len(worklog); i++ {
+		if worklog[i].WorklogID == ID && worklog[i].TaskID == vars["id"] {
+			n = i
+		}
+	}
+	if err != nil || n < 0 {
+		http.NotFound(w, r)
+		return
+	}
+	worklog = append(worklog[:n], worklog[n+1:]...)
+	_, err = w.Write([]byte("worklog entry deleted"))
+	end := time.Now()
+	execTime := end.Sub(start)
+	if err != nil {
+		log.WithFields(log.Fields{"execution time": execTime}).Fatal("worklogDeleteHandler ended: " + err.Error())
+	}
+	log.WithFields(log.Fields{"execution time": execTime}).Info("worklogDeleteHandler ended")
+}
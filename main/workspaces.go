@@ -0,0 +1,406 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+type workspace struct {
+	Name          string `json:"name"`
+	DefaultParent *int   `json:"default_parent,omitempty"`
+	DefaultGroup  *int   `json:"default_group,omitempty"`
+	Limit         *int   `json:"limit,omitempty"`
+	CreatedDate   string `json:"created_at"`
+}
+
+type workspaceData struct {
+	Groups      []group
+	Tasks       []task
+	Trash       trashBin
+	Comments    []comment
+	Activity    []activityEvent
+	Attachments []attachment
+	Worklog     []worklogEntry
+	Revisions   []revision
+	Grants      []groupGrant
+	Config      *viper.Viper
+}
+
+const defaultWorkspace = "default"
+
+const workspacePrefix = "/w/{workspace:[a-z0-9_-]+}"
+
+const workspaceKey contextKey = "workspace"
+
+const routeKey contextKey = "route"
+
+var workspaceName = regexp.MustCompile(`^[a-z0-9_-]+$`)
+
+var baseConfig = config
+
+var workspaces = readWorkspaces()
+
+var workspaceStore = map[string]*workspaceData{}
+
+var activeWorkspace = defaultWorkspace
+
+func readWorkspaces() []workspace {
+	var ws []workspace
+	readJSONFile("workspaces.json", &ws)
+	return ws
+}
+
+func initWorkspaces() {
+	if getWorkspaceNum(defaultWorkspace) < 0 {
+		workspaces = append([]workspace{{Name: defaultWorkspace, CreatedDate: time.Now().Format(time.RFC3339Nano)}}, workspaces...)
+	}
+	config = getWorkspaceConfig(workspaces[getWorkspaceNum(defaultWorkspace)])
+}
+
+func getWorkspaceNum(name string) int {
+	for i := 0; i < len(workspaces); i++ {
+		if workspaces[i].Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func getWorkspaceFile(name string, file string) string {
+	if name == defaultWorkspace {
+		return file
+	}
+	return filepath.Join(baseConfig.GetString("Workspaces.dir"), name, file)
+}
+
+func getWorkspaceConfig(ws workspace) *viper.Viper {
+	v := viper.New()
+	err := v.MergeConfigMap(baseConfig.AllSettings())
+	if err != nil {
+		log.Fatal(err)
+	}
+	if ws.Name != defaultWorkspace {
+		v.Set("Attachments.dir", getWorkspaceFile(ws.Name, baseConfig.GetString("Attachments.dir")))
+	}
+	if ws.DefaultParent != nil {
+		v.Set("Groups.default_parent", *ws.DefaultParent)
+	}
+	if ws.DefaultGroup != nil {
+		v.Set("Tasks.default_group", *ws.DefaultGroup)
+	}
+	if ws.Limit != nil {
+		v.Set("Groups.limit", *ws.Limit)
+	}
+	return v
+}
+
+func loadWorkspace(ws workspace) *workspaceData {
+	d := &workspaceData{Config: getWorkspaceConfig(ws)}
+	readJSONFile(getWorkspaceFile(ws.Name, "groups.json"), &d.Groups)
+	readJSONFile(getWorkspaceFile(ws.Name, "tasks.json"), &d.Tasks)
+	readJSONFile(getWorkspaceFile(ws.Name, "trash.json"), &d.Trash)
+	readJSONFile(getWorkspaceFile(ws.Name, "comments.json"), &d.Comments)
+	readJSONFile(getWorkspaceFile(ws.Name, "activity.json"), &d.Activity)
+	readJSONFile(getWorkspaceFile(ws.Name, "attachments.json"), &d.Attachments)
+	readJSONFile(getWorkspaceFile(ws.Name, "worklog.json"), &d.Worklog)
+	readJSONFile(getWorkspaceFile(ws.Name, "revisions.json"), &d.Revisions)
+	readJSONFile(getWorkspaceFile(ws.Name, "grants.json"), &d.Grants)
+	return d
+}
+
+func captureWorkspace() *workspaceData {
+	return &workspaceData{
+		Groups:      taskGroups,
+		Tasks:       tasks,
+		Trash:       trash,
+		Comments:    comments,
+		Activity:    activity,
+		Attachments: attachments,
+		Worklog:     worklog,
+		Revisions:   revisions,
+		Grants:      grants,
+		Config:      config,
+	}
+}
+
+func switchWorkspace(name string) {
+	if name == activeWorkspace {
+		return
+	}
+	workspaceStore[activeWorkspace] = captureWorkspace()
+	d := workspaceStore[name]
+	if d == nil {
+		d = loadWorkspace(workspaces[getWorkspaceNum(name)])
+	}
+	taskGroups, tasks, trash = d.Groups, d.Tasks, d.Trash
+	comments, activity, attachments = d.Comments, d.Activity, d.Attachments
+	worklog, revisions, grants = d.Worklog, d.Revisions, d.Grants
+	config = d.Config
+	activeWorkspace = name
+}
+
+func forEachWorkspace(fn func()) {
+	current := activeWorkspace
+	for i := 0; i < len(workspaces); i++ {
+		switchWorkspace(workspaces[i].Name)
+		fn()
+	}
+	switchWorkspace(current)
+}
+
+func runInWorkspace(r *http.Request, name string, fn func()) {
+	requested := activeWorkspace
+	renames := taskRenames
+	switchWorkspace(name)
+	if name == requested {
+		fn()
+	} else if changes := trackChanges(requestActor(r), fn); len(changes) != 0 {
+		auditRequest(r, http.StatusOK, changes)
+	}
+	switchWorkspace(requested)
+	taskRenames = renames
+}
+
+func forEachWorkspaceTracked(r *http.Request, fn func()) {
+	for i := 0; i < len(workspaces); i++ {
+		runInWorkspace(r, workspaces[i].Name, fn)
+	}
+}
+
+func removeWorkspaceUser(username string, actor string) {
+	removeUserGrants(username)
+	mark := len(activity)
+	tasks = unassignUser(tasks, username)
+	setActivityActor(mark, actor)
+}
+
+func writeWorkspaces() {
+	workspaceStore[activeWorkspace] = captureWorkspace()
+	for name, d := range workspaceStore {
+		if name != defaultWorkspace {
+			err := os.MkdirAll(getWorkspaceFile(name, ""), 0755)
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
+		writeJSONFile(getWorkspaceFile(name, "groups.json"), d.Groups)
+		writeJSONFile(getWorkspaceFile(name, "tasks.json"), d.Tasks)
+		writeJSONFile(getWorkspaceFile(name, "trash.json"), d.Trash)
+		writeJSONFile(getWorkspaceFile(name, "comments.json"), d.Comments)
+		writeJSONFile(getWorkspaceFile(name, "activity.json"), d.Activity)
+		writeJSONFile(getWorkspaceFile(name, "attachments.json"), d.Attachments)
+		writeJSONFile(getWorkspaceFile(name, "worklog.json"), d.Worklog)
+		writeJSONFile(getWorkspaceFile(name, "revisions.json"), d.Revisions)
+		writeJSONFile(getWorkspaceFile(name, "grants.json"), d.Grants)
+		log.WithField("Workspace: ", name).Info("workspace successfully wrote")
+	}
+	writeJSONFile("workspaces.json", workspaces)
+}
+
+func getUserWorkspaces(u user) []string {
+	if len(u.Workspaces) == 0 {
+		return []string{defaultWorkspace}
+	}
+	return u.Workspaces
+}
+
+func canAccessWorkspace(u user, name string) bool {
+	return u.Admin || containsString(getUserWorkspaces(u), name)
+}
+
+func getRouteTemplate(r *http.Request) string {
+	if template, ok := r.Context().Value(routeKey).(string); ok {
+		return template
+	}
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return ""
+	}
+	template, _ := route.GetPathTemplate()
+	return strings.TrimPrefix(template, workspacePrefix)
+}
+
+func selectWorkspace(w http.ResponseWriter, r *http.Request) bool {
+	u, ok := requestUser(r)
+	if !ok {
+		return true
+	}
+	bound, _ := r.Context().Value(workspaceKey).(string)
+	name := mux.Vars(r)["workspace"]
+	if name == "" {
+		name = bound
+	}
+	if name == "" {
+		name = getUserWorkspaces(u)[0]
+	}
+	if getWorkspaceNum(name) < 0 || !canAccessWorkspace(u, name) {
+		http.NotFound(w, r)
+		log.WithFields(log.Fields{"Username: ": u.Username, "Workspace: ": name}).Warn("Workspace is not available.")
+		return false
+	}
+	if bound != "" && bound != name {
+		http.Error(w, "403 token is bound to workspace "+bound, http.StatusForbidden)
+		log.WithFields(log.Fields{"Username: ": u.Username, "Workspace: ": name}).Warn("Token workspace mismatch.")
+		return false
+	}
+	switchWorkspace(name)
+	return true
+}
+
+func validateWorkspaceNames(names []string) error {
+	for i := 0; i < len(names); i++ {
+		if getWorkspaceNum(names[i]) < 0 {
+			return errors.New("workspace " + names[i] + " does not exist")
+		}
+	}
+	return nil
+}
+
+func workspacesHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	log.WithFields(log.Fields{"method": r.Method, "url": r.URL.String()}).Info("workspacesHandler started")
+	u, _ := requestUser(r)
+	ws := []workspace{}
+	for i := 0; i < len(workspaces); i++ {
+		if canAccessWorkspace(u, workspaces[i].Name) {
+			ws = append(ws, workspaces[i])
+		}
+	}
+	err := json.NewEncoder(w).Encode(ws)
+	end := time.Now()
+	execTime := end.Sub(start)
+	if err != nil {
+		log.WithFields(log.Fields{"execution time": execTime}).Fatal("workspacesHandler ended: " + err.Error())
+	}
+	log.WithFields(log.Fields{"execution time": execTime}).Info("workspacesHandler ended")
+}
+
+func newWorkspaceHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	log.WithFields(log.Fields{"method": r.Method, "url": r.URL.String()}).Info("newWorkspaceHandler started")
+	if !requireAdmin(w, r) {
+		return
+	}
+	var ws workspace
+	err := json.NewDecoder(r.Body).Decode(&ws)
+	if err != nil {
+		http.Error(w, "400 "+err.Error(), http.StatusBadRequest)
+		log.Error("Decoding workspace from request body: ", err.Error())
+		return
+	}
+	if !workspaceName.MatchString(ws.Name) {
+		http.Error(w, "400 workspace name must consist of lowercase letters, digits, dashes and underscores", http.StatusBadRequest)
+		log.WithField("Workspace: ", ws.Name).Warn("Invalid workspace name.")
+		return
+	}
+	if getWorkspaceNum(ws.Name) >= 0 {
+		http.Error(w, "400 workspace with this name already exists", http.StatusBadRequest)
+		log.WithField("Workspace: ", ws.Name).Warn("Workspace already exists.")
+		return
+	}
+	ws.CreatedDate = time.Now().Format(time.RFC3339Nano)
+	workspaces = append(workspaces, ws)
+	err = json.NewEncoder(w).Encode(ws)
+	end := time.Now()
+	execTime := end.Sub(start)
+	if err != nil {
+		log.WithFields(log.Fields{"execution time": execTime}).Fatal("newWorkspaceHandler ended: " + err.Error())
+	}
+	log.WithFields(log.Fields{"execution time": execTime}).Info("newWorkspaceHandler ended")
+}
+
+func workspaceEditHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	log.WithFields(log.Fields{"method": r.Method, "url": r.URL.String()}).Info("workspaceEditHandler started")
+	if !requireAdmin(w, r) {
+		return
+	}
+	vars := mux.Vars(r)
+	n := getWorkspaceNum(vars["name"])
+	if n < 0 {
+		http.NotFound(w, r)
+		return
+	}
+	ws := workspaces[n]
+	err := json.NewDecoder(r.Body).Decode(&ws)
+	if err != nil {
+		http.Error(w, "400 "+err.Error(), http.StatusBadRequest)
+		log.Error("Decoding workspace from request body: ", err.Error())
+		return
+	}
+	ws.Name = workspaces[n].Name
+	ws.CreatedDate = workspaces[n].CreatedDate
+	workspaces[n] = ws
+	if ws.Name == activeWorkspace {
+		config = getWorkspaceConfig(ws)
+	} else if d := workspaceStore[ws.Name]; d != nil {
+		d.Config = getWorkspaceConfig(ws)
+	}
+	err = json.NewEncoder(w).Encode(ws)
+	end := time.Now()
+	execTime := end.Sub(start)
+	if err != nil {
+		log.WithFields(log.Fields{"execution time": execTime}).Fatal("workspaceEditHandler ended: " + err.Error())
+	}
+	log.WithFields(log.Fields{"execution time": execTime}).Info("workspaceEditHandler ended")
+}
+
+func workspaceMemberHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	log.WithFields(log.Fields{"method": r.Method, "url": r.URL.String()}).Info("workspaceMemberHandler started")
+	if !requireAdmin(w, r) {
+		return
+	}
+	vars := mux.Vars(r)
+	if getWorkspaceNum(vars["name"]) < 0 {
+		http.NotFound(w, r)
+		return
+	}
+	authMu.Lock()
+	defer authMu.Unlock()
+	n := getUserNum(users, vars["username"])
+	if n < 0 {
+		http.NotFound(w, r)
+		return
+	}
+	member := containsString(getUserWorkspaces(users[n]), vars["name"])
+	if r.Method == "PUT" && !member {
+		users[n].Workspaces = append(getUserWorkspaces(users[n]), vars["name"])
+	}
+	if r.Method == "DELETE" && member {
+		var names []string
+		current := getUserWorkspaces(users[n])
+		for i := 0; i < len(current); i++ {
+			if current[i] != vars["name"] {
+				names = append(names, current[i])
+			}
+		}
+		if names == nil {
+			http.Error(w, "400 user must belong to at least one workspace", http.StatusBadRequest)
+			log.WithField("Username: ", vars["username"]).Warn("Removing last workspace.")
+			return
+		}
+		users[n].Workspaces = names
+		runInWorkspace(r, vars["name"], func() {
+			removeWorkspaceUser(vars["username"], requestActor(r))
+		})
+	}
+	u := users[n]
+	u.PasswordHash = ""
+	err := json.NewEncoder(w).Encode(u)
+	end := time.Now()
+	execTime := end.Sub(start)
+	if err != nil {
+		log.WithFields(log.Fields{"execution time": execTime}).Fatal("workspaceMemberHandler ended: " + err.Error())
+	}
+	log.WithFields(log.Fields{"execution time": execTime}).Info("workspaceMemberHandler ended")
+}
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+type wsMessage struct {
+	ID          string          `json:"id,omitempty"`
+	Type        string          `json:"type"`
+	GroupID     int             `json:"group_id,omitempty"`
+	LastEventID string          `json:"last_event_id,omitempty"`
+	TaskID      string          `json:"task_id,omitempty"`
+	Body        json.RawMessage `json:"body,omitempty"`
+}
+
+type wsReply struct {
+	ID      string          `json:"id,omitempty"`
+	Type    string          `json:"type"`
+	Status  int             `json:"status,omitempty"`
+	GroupID int             `json:"group_id,omitempty"`
+	Body    json.RawMessage `json:"body,omitempty"`
+	Error   string          `json:"error,omitempty"`
+	Event   *streamEvent    `json:"event,omitempty"`
+}
+
+type wsClient struct {
+	conn       *websocket.Conn
+	sub        *eventSubscriber
+	remoteAddr string
+	replies    chan wsReply
+	closed     chan struct{}
+	stopped    chan struct{}
+}
+
+func checkWSOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" || containsString(baseConfig.GetStringSlice("WebSocket.allowed_origins"), origin) {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
+
+func getWSDuration(key string, def time.Duration) time.Duration {
+	d, err := time.ParseDuration(baseConfig.GetString(key))
+	if err != nil || d <= 0 {
+		return def
+	}
+	return d
+}
+
+func (c *wsClient) send(reply wsReply) {
+	select {
+	case c.replies <- reply:
+	case <-c.stopped:
+	}
+}
+
+func (c *wsClient) write(reply wsReply) error {
+	err := c.conn.SetWriteDeadline(time.Now().Add(getWSDuration("WebSocket.write_timeout", 10*time.Second)))
+	if err != nil {
+		return err
+	}
+	return c.conn.WriteJSON(reply)
+}
+
+func (c *wsClient) writeLoop() {
+	defer close(c.stopped)
+	defer c.conn.Close()
+	ticker := time.NewTicker(getWSDuration("WebSocket.ping_interval", 30*time.Second))
+	defer ticker.Stop()
+	for {
+		var err error
+		select {
+		case reply := <-c.replies:
+			err = c.write(reply)
+		case e := <-c.sub.events:
+			err = c.write(wsReply{Type: "event", Event: &e})
+		case <-ticker.C:
+			if revalidateSubscriber(c.sub) {
+				err = c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(getWSDuration("WebSocket.write_timeout", 10*time.Second)))
+			}
+		case <-c.sub.done:
+			msg := websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "subscription closed, reconnect with last_event_id")
+			if c.sub.revoked {
+				msg = websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "authorization revoked")
+			}
+			_ = c.conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+			return
+		case <-c.closed:
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (c *wsClient) readLoop() error {
+	pongWait := getWSDuration("WebSocket.pong_timeout", 60*time.Second)
+	c.conn.SetReadLimit(baseConfig.GetInt64("WebSocket.max_message_size"))
+	_ = c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+	for {
+		_, data, err := c.conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+		_ = c.conn.SetReadDeadline(time.Now().Add(pongWait))
+		var msg wsMessage
+		err = json.Unmarshal(data, &msg)
+		if err != nil {
+			c.send(wsReply{Type: "error", Error: "invalid message: " + err.Error()})
+			continue
+		}
+		c.handle(msg)
+	}
+}
+
+func (c *wsClient) handle(msg wsMessage) {
+	switch msg.Type {
+	case "ping":
+		c.send(wsReply{ID: msg.ID, Type: "pong"})
+	case "subscribe":
+		c.subscribe(msg)
+	case "unsubscribe":
+		c.unsubscribe(msg)
+	case "create_task", "update_task", "delete_task":
+		c.send(c.dispatch(msg))
+	default:
+		c.send(wsReply{ID: msg.ID, Type: "error", Error: "unknown message type " + msg.Type})
+	}
+}
+
+func (c *wsClient) subscribe(msg wsMessage) {
+	storeMu.Lock()
+	switchWorkspace(c.sub.workspace)
+	eventMu.Lock()
+	u := c.sub.user
+	eventMu.Unlock()
+	if msg.GroupID != 0 && !canSubscribeGroup(u, msg.GroupID) {
+		storeMu.Unlock()
+		c.send(wsReply{ID: msg.ID, Type: "error", Status: http.StatusNotFound, Error: "group with this ID does not exist"})
+		return
+	}
+	eventMu.Lock()
+	if msg.GroupID == 0 {
+		c.sub.all = true
+	} else if !containsInt(c.sub.groupIDs, msg.GroupID) {
+		c.sub.groupIDs = append(c.sub.groupIDs, msg.GroupID)
+	}
+	filter := *c.sub
+	filter.all = msg.GroupID == 0
+	filter.groupIDs = []int{msg.GroupID}
+	missed, reset := getMissedEvents(&filter, msg.LastEventID)
+	eventMu.Unlock()
+	storeMu.Unlock()
+	c.send(wsReply{ID: msg.ID, Type: "subscribed", GroupID: msg.GroupID})
+	if reset {
+		c.send(wsReply{ID: msg.ID, Type: "reset", GroupID: msg.GroupID})
+	}
+	for i := 0; i < len(missed); i++ {
+		c.send(wsReply{Type: "event", Event: &missed[i]})
+	}
+}
+
+func (c *wsClient) unsubscribe(msg wsMessage) {
+	eventMu.Lock()
+	if msg.GroupID == 0 {
+		c.sub.all = false
+		c.sub.groupIDs = nil
+	} else {
+		var ids []int
+		for i := 0; i < len(c.sub.groupIDs); i++ {
+			if c.sub.groupIDs[i] != msg.GroupID {
+				ids = append(ids, c.sub.groupIDs[i])
+			}
+		}
+		c.sub.groupIDs = ids
+	}
+	eventMu.Unlock()
+	c.send(wsReply{ID: msg.ID, Type: "unsubscribed", GroupID: msg.GroupID})
+}
+
+func getWSRequest(msg wsMessage) (string, string, string, error) {
+	if msg.Type == "create_task" {
+		return "POST", "/tasks/new", "/tasks/new", nil
+	}
+	if msg.TaskID == "" {
+		return "", "", "", errors.New("task_id is not specified")
+	}
+	method := "PUT"
+	if msg.Type == "delete_task" {
+		method = "DELETE"
+	}
+	return method, "/tasks/{id:[a-zA-Z0-9]+}", "/tasks/" + url.PathEscape(msg.TaskID), nil
+}
+
+func (c *wsClient) newRequest(msg wsMessage) (*http.Request, error) {
+	method, template, path, err := getWSRequest(msg)
+	if err != nil {
+		return nil, statusError{http.StatusBadRequest, err.Error()}
+	}
+	u, scopes, bound, ok := authenticateBearer(c.sub.token)
+	if !ok || (bound != "" && bound != c.sub.workspace) || !canAccessWorkspace(u, c.sub.workspace) {
+		return nil, statusError{http.StatusUnauthorized, "unauthorized"}
+	}
+	ctx := context.WithValue(context.Background(), requestIDKey, getRequestID(msg.ID))
+	ctx = context.WithValue(ctx, userKey, u)
+	ctx = context.WithValue(ctx, routeKey, template)
+	if scopes != nil {
+		ctx = context.WithValue(ctx, scopesKey, scopes)
+	}
+	r, err := http.NewRequestWithContext(ctx, method, "/w/"+c.sub.workspace+path, nil)
+	if err != nil {
+		return nil, statusError{http.StatusBadRequest, err.Error()}
+	}
+	r.RemoteAddr = c.remoteAddr
+	r = mux.SetURLVars(r, map[string]string{"workspace": c.sub.workspace, "id": msg.TaskID})
+	if !checkScopes(r, scopes) {
+		return nil, statusError{http.StatusForbidden, "token scope does not allow this request"}
+	}
+	return r, nil
+}
+
+func runWSTaskRequest(r *http.Request, msg wsMessage) (interface{}, error) {
+	if msg.Type != "create_task" {
+		if !containsTask(tasks, msg.TaskID) {
+			return nil, statusError{http.StatusNotFound, "task with this ID does not exist"}
+		}
+		u, _ := requestUser(r)
+		err := checkGroupAccess(u, getTaskGroupID(msg.TaskID), "editor")
+		if err != nil {
+			return nil, err
+		}
+	}
+	var t task
+	if msg.Type != "delete_task" {
+		err := json.Unmarshal(msg.Body, &t)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var err error
+	switch msg.Type {
+	case "create_task":
+		t, err = createTask(r, t)
+	case "update_task":
+		t, err = updateTask(r, msg.TaskID, t)
+	default:
+		mark := len(activity)
+		tasks, err = deleteTask(tasks, msg.TaskID, false)
+		if err != nil {
+			return nil, err
+		}
+		setActivityActor(mark, requestActor(r))
+		return "task deleted", nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return decorateTask(tasks, t), nil
+}
+
+func (c *wsClient) dispatch(msg wsMessage) wsReply {
+	reply := wsReply{ID: msg.ID, Type: "response", Status: http.StatusOK}
+	r, err := c.newRequest(msg)
+	if err != nil {
+		reply.Status = getErrorCode(err)
+		reply.Error = err.Error()
+		return reply
+	}
+	storeMu.Lock()
+	defer storeMu.Unlock()
+	if getWorkspaceNum(c.sub.workspace) < 0 {
+		reply.Status = http.StatusNotFound
+		reply.Error = "workspace does not exist"
+		return reply
+	}
+	switchWorkspace(c.sub.workspace)
+	var result interface{}
+	changes := trackChanges(requestActor(r), func() {
+		result, err = runWSTaskRequest(r, msg)
+	})
+	if len(changes) != 0 {
+		pushUndo(undoScope(r), changes)
+	}
+	if err != nil {
+		reply.Status = getErrorCode(err)
+		reply.Error = err.Error()
+		log.WithField("Task ID: ", msg.TaskID).Warn("WebSocket task request: ", err.Error())
+	} else {
+		reply.Body, _ = json.Marshal(result)
+	}
+	auditRequest(r, reply.Status, changes)
+	return reply
+}
+
+func wsHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	log.WithFields(log.Fields{"method": r.Method, "url": getLogURL(r)}).Info("wsHandler started")
+	s, _, _, ok := newEventSubscriber(w, r, false)
+	if !ok {
+		return
+	}
+	defer unsubscribeEvents(s)
+	upgrader := websocket.Upgrader{CheckOrigin: checkWSOrigin}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Warn("Upgrading to websocket: ", err.Error())
+		return
+	}
+	c := &wsClient{
+		conn:       conn,
+		sub:        s,
+		remoteAddr: r.RemoteAddr,
+		replies:    make(chan wsReply, 16),
+		closed:     make(chan struct{}),
+		stopped:    make(chan struct{}),
+	}
+	go c.writeLoop()
+	err = c.readLoop()
+	close(c.closed)
+	<-c.stopped
+	end := time.Now()
+	execTime := end.Sub(start)
+	log.WithFields(log.Fields{"execution time": execTime}).Info("wsHandler ended: " + err.Error())
+}